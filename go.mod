@@ -6,8 +6,12 @@ require (
 	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/ethereum/go-ethereum v1.13.15
 	github.com/gitploy-io/cronexpr v0.2.2
+	github.com/google/go-cmp v0.6.0
+	github.com/google/uuid v1.5.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/kwilteam/kwil-db v0.7.3
 	github.com/kwilteam/kwil-db/core v0.1.2
+	golang.org/x/time v0.5.0
 	gotest.tools v2.2.0+incompatible
 )
 
@@ -51,10 +55,7 @@ require (
 	github.com/golang/snappy v0.0.5-0.20220116011046-fa5810519dcb // indirect
 	github.com/google/btree v1.1.2 // indirect
 	github.com/google/flatbuffers v1.12.1 // indirect
-	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/google/orderedcode v0.0.1 // indirect
-	github.com/google/uuid v1.5.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/grpc-ecosystem/go-grpc-middleware/v2 v2.0.0-rc.5 // indirect
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.18.0 // indirect
 	github.com/hashicorp/hcl v1.0.0 // indirect
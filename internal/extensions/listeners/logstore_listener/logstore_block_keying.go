@@ -0,0 +1,143 @@
+package logstore_listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kwilteam/kwil-db/extensions/listeners"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"github.com/usherlabs/kwil-ls-oracle/internal/paginated_poll_listener"
+	"sync"
+)
+
+// LogStoreBlockKeying is a keying service that uses Polygon block heights as keys.
+// Windows close when the chain has enough confirmed blocks, instead of relying on the local clock.
+// it should implement the [paginated_poll_listener.KeyingService] interface.
+type LogStoreBlockKeying struct {
+	chain         *logstore_client.ChainReader
+	startingBlock *int64 // optional
+	// blockInterval is the number of blocks in each window
+	blockInterval int64
+	// confirmations is the number of blocks we wait before considering a block final
+	confirmations int64
+
+	// block timestamps never change, and each window boundary is used twice
+	timestampsMu sync.Mutex
+	timestamps   map[int64]int64
+}
+
+var _ paginated_poll_listener.KeyingService = (*LogStoreBlockKeying)(nil)
+
+type NewLogStoreBlockKeyingOptions struct {
+	Chain         *logstore_client.ChainReader
+	StartingBlock *int64
+	BlockInterval int64
+	Confirmations int64
+}
+
+func NewLogStoreBlockKeying(options NewLogStoreBlockKeyingOptions) *LogStoreBlockKeying {
+	if options.BlockInterval < 1 {
+		panic(fmt.Sprintf("invalid block interval: %d", options.BlockInterval))
+	}
+
+	return &LogStoreBlockKeying{
+		chain:         options.Chain,
+		startingBlock: options.StartingBlock,
+		blockInterval: options.BlockInterval,
+		confirmations: options.Confirmations,
+		timestamps:    make(map[int64]int64),
+	}
+}
+
+// GetStartingKey gets the starting block for the logstore listener.
+// Without a configured starting block, it returns 0, which makes the poller start from the current block.
+func (l *LogStoreBlockKeying) GetStartingKey(ctx context.Context) (int64, error) {
+	if l.startingBlock != nil {
+		return *l.startingBlock, nil
+	}
+	return 0, nil
+}
+
+// GetCurrentKey gets the latest block of the chain, minus the confirmations.
+// Like the overhead delay of the timestamp keying, the confirmations give the log store node time to store messages.
+func (l *LogStoreBlockKeying) GetCurrentKey(ctx context.Context) (int64, error) {
+	height, err := l.chain.GetLatestBlockHeight(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return height - l.confirmations, nil
+}
+
+// GetKeyAfter gets the next window boundary after the given block.
+func (l *LogStoreBlockKeying) GetKeyAfter(ctx context.Context, key int64) (int64, error) {
+	return (key/l.blockInterval + 1) * l.blockInterval, nil
+}
+
+// GetKeyBefore gets the window boundary at or before the given block.
+func (l *LogStoreBlockKeying) GetKeyBefore(ctx context.Context, key int64) (int64, error) {
+	return key / l.blockInterval * l.blockInterval, nil
+}
+
+// KeyToTimestamp converts a block height into its timestamp, so messages can be queried by time.
+func (l *LogStoreBlockKeying) KeyToTimestamp(ctx context.Context, key int64) (int64, error) {
+	l.timestampsMu.Lock()
+	defer l.timestampsMu.Unlock()
+
+	if timestamp, ok := l.timestamps[key]; ok {
+		return timestamp, nil
+	}
+
+	timestamp, err := l.chain.GetBlockTimestamp(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	// we only need the boundaries of the windows being processed
+	if len(l.timestamps) > 1024 {
+		l.timestamps = make(map[int64]int64)
+	}
+	l.timestamps[key] = timestamp
+
+	return timestamp, nil
+}
+
+// keyingModeKey stores the keying mode the stored keys were written with
+var keyingModeKey = []byte("keying_mode")
+
+// ErrKeyingModeChanged is returned when the keying mode differs from the one of the stored keys
+var ErrKeyingModeChanged = errors.New("keying mode changed")
+
+// checkKeyingMode makes sure the stored keys were written with the configured keying mode, storing it on the first run.
+// Timestamps and block heights share the stored keys, so a block height would be read as a timestamp from 1970, and a
+// timestamp as a block height far in the future.
+func checkKeyingMode(ctx context.Context, eventstore listeners.EventStore, mode string) error {
+	stored, err := eventstore.Get(ctx, keyingModeKey)
+	if err != nil {
+		return fmt.Errorf("failed to get keying mode: %w", err)
+	}
+
+	storedMode := string(stored)
+	if storedMode == "" {
+		hasKeys, err := paginated_poll_listener.HasStoredKeys(ctx, eventstore)
+		if err != nil {
+			return err
+		}
+		// keys stored before the keying mode was recorded are timestamps, the only mode back then
+		if hasKeys {
+			storedMode = KeyingModeTimestamp
+		}
+	}
+
+	if storedMode != "" && storedMode != mode {
+		return fmt.Errorf("%w: the stored keys are of keying_mode %q, but %q is configured", ErrKeyingModeChanged, storedMode, mode)
+	}
+
+	if len(stored) == 0 {
+		err = eventstore.Set(ctx, keyingModeKey, []byte(mode))
+		if err != nil {
+			return fmt.Errorf("failed to set keying mode: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package logstore_listener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"gotest.tools/assert"
+)
+
+// fakeChain is a JSON-RPC server answering the latest block height, and block headers
+// whose timestamp in seconds is ten times their height
+type fakeChain struct {
+	*httptest.Server
+
+	mu           sync.Mutex
+	latestHeight int64
+	headerCalls  int
+}
+
+func newFakeChain(latestHeight int64) *fakeChain {
+	chain := &fakeChain{latestHeight: latestHeight}
+	chain.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Id     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+
+		var result any
+		switch request.Method {
+		case "eth_blockNumber":
+			result = hexutil.Uint64(chain.latestHeight)
+		case "eth_getBlockByNumber":
+			chain.headerCalls++
+			var height hexutil.Big
+			if err := json.Unmarshal(request.Params[0], &height); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result = &types.Header{
+				Number:     height.ToInt(),
+				Time:       height.ToInt().Uint64() * 10,
+				Difficulty: big.NewInt(0),
+			}
+		default:
+			http.Error(w, "unknown method", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": request.Id, "result": result})
+	}))
+	return chain
+}
+
+func Test_BlockKeying(t *testing.T) {
+	ctx := context.Background()
+
+	server := newFakeChain(1005)
+	defer server.Close()
+
+	chain, err := logstore_client.NewChainReader(server.URL)
+	assert.NilError(t, err)
+	defer chain.Close()
+
+	keying := NewLogStoreBlockKeying(NewLogStoreBlockKeyingOptions{
+		Chain:         chain,
+		BlockInterval: 30,
+		Confirmations: 5,
+	})
+
+	t.Run("Current key is the latest confirmed block", func(t *testing.T) {
+		key, err := keying.GetCurrentKey(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, key, int64(1000))
+	})
+
+	t.Run("Keys are aligned to the block interval", func(t *testing.T) {
+		after, err := keying.GetKeyAfter(ctx, 990)
+		assert.NilError(t, err)
+		assert.Equal(t, after, int64(1020))
+
+		before, err := keying.GetKeyBefore(ctx, 1000)
+		assert.NilError(t, err)
+		assert.Equal(t, before, int64(990))
+
+		before, err = keying.GetKeyBefore(ctx, 990)
+		assert.NilError(t, err)
+		assert.Equal(t, before, int64(990))
+	})
+
+	t.Run("Block timestamps are in milliseconds, and cached", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			timestamp, err := keying.KeyToTimestamp(ctx, 990)
+			assert.NilError(t, err)
+			assert.Equal(t, timestamp, int64(9_900_000))
+		}
+
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		assert.Equal(t, server.headerCalls, 1)
+	})
+}
+
+func Test_CheckKeyingMode(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("The first keying mode is stored", func(t *testing.T) {
+		eventstore := &memoryEventStore{values: make(map[string][]byte)}
+
+		assert.NilError(t, checkKeyingMode(ctx, eventstore, KeyingModeBlock))
+		assert.NilError(t, checkKeyingMode(ctx, eventstore, KeyingModeBlock))
+
+		err := checkKeyingMode(ctx, eventstore, KeyingModeTimestamp)
+		assert.Assert(t, errors.Is(err, ErrKeyingModeChanged), "%v", err)
+	})
+
+	t.Run("Keys stored before the keying mode are timestamps", func(t *testing.T) {
+		eventstore := &memoryEventStore{values: map[string][]byte{"lk": make([]byte, 8)}}
+
+		err := checkKeyingMode(ctx, eventstore, KeyingModeBlock)
+		assert.Assert(t, errors.Is(err, ErrKeyingModeChanged), "%v", err)
+
+		assert.NilError(t, checkKeyingMode(ctx, eventstore, KeyingModeTimestamp))
+		stored, err := eventstore.Get(ctx, keyingModeKey)
+		assert.NilError(t, err)
+		assert.Equal(t, string(stored), KeyingModeTimestamp)
+	})
+}
@@ -0,0 +1,126 @@
+package logstore_listener
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/kwilteam/kwil-db/extensions/listeners"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"strconv"
+	"strings"
+)
+
+var (
+	// chainGapsMetric counts the gaps found in windows that were broadcast with them
+	chainGapsMetric = expvar.NewInt("logstore_listener_chain_gaps")
+	// chainGapRequeriesMetric counts the windows held back and re-queried because of gaps
+	chainGapRequeriesMetric = expvar.NewInt("logstore_listener_chain_gap_requeries")
+)
+
+// chainKeyPrefix prefixes the eventstore keys of the last message seen on each message chain
+const chainKeyPrefix = "chain:"
+
+// chainGap is a hole in a message chain: the messages after LastSeen and before Previous were never received
+type chainGap struct {
+	StreamId        string
+	StreamPartition int
+	PublisherId     string
+	MsgChainId      string
+	// LastSeen is the last message received on the chain
+	LastSeen logstore_client.Cursor
+	// Previous is the previous message the next received message points to
+	Previous logstore_client.Cursor
+}
+
+func (g chainGap) String() string {
+	return fmt.Sprintf("%s#%d %s/%s: missing messages after %s up to %s", g.StreamId, g.StreamPartition, g.PublisherId, g.MsgChainId, g.LastSeen, g.Previous)
+}
+
+// chainTracker finds gaps in the message chains of a window, comparing each message with the last one seen on its chain.
+// The last message of each chain is kept in the eventstore, so gaps between windows are found too.
+type chainTracker struct {
+	eventstore listeners.EventStore
+	// lastSeen caches the stored positions, and holds the ones not committed yet
+	lastSeen map[string]*logstore_client.Cursor
+	changed  map[string]struct{}
+	gaps     []chainGap
+}
+
+func newChainTracker(eventstore listeners.EventStore) *chainTracker {
+	return &chainTracker{
+		eventstore: eventstore,
+		lastSeen:   make(map[string]*logstore_client.Cursor),
+		changed:    make(map[string]struct{}),
+	}
+}
+
+// Observe checks the message against the last one seen on its chain. Messages must be observed in chain order.
+func (t *chainTracker) Observe(ctx context.Context, message *logstore_client.JSONStreamMessage) error {
+	key := chainKey(message.StreamId, message.StreamPartition, message.PublisherId, message.MsgChainId)
+	lastSeen, err := t.getLastSeen(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	position := logstore_client.CursorOf(message)
+	// a window may be processed again, after a failure
+	if lastSeen != nil && position.Compare(*lastSeen) <= 0 {
+		return nil
+	}
+
+	// we can't tell what is missing before the first message we see on a chain
+	if lastSeen != nil && message.PrevMsgRef != nil {
+		previous := logstore_client.Cursor{Timestamp: message.PrevMsgRef.Timestamp, SequenceNumber: message.PrevMsgRef.SequenceNumber}
+		if previous.Compare(*lastSeen) > 0 {
+			t.gaps = append(t.gaps, chainGap{
+				StreamId:        message.StreamId,
+				StreamPartition: message.StreamPartition,
+				PublisherId:     message.PublisherId,
+				MsgChainId:      message.MsgChainId,
+				LastSeen:        *lastSeen,
+				Previous:        previous,
+			})
+		}
+	}
+
+	t.lastSeen[key] = &position
+	t.changed[key] = struct{}{}
+	return nil
+}
+
+// Gaps returns the gaps found so far
+func (t *chainTracker) Gaps() []chainGap {
+	return t.gaps
+}
+
+// Commit stores the last message seen on each chain. It must only be called once the window was broadcast.
+func (t *chainTracker) Commit(ctx context.Context) error {
+	for key := range t.changed {
+		err := t.eventstore.Set(ctx, []byte(key), encodeCursor(*t.lastSeen[key]))
+		if err != nil {
+			return fmt.Errorf("failed to store chain position: %w", err)
+		}
+	}
+	t.changed = make(map[string]struct{})
+	return nil
+}
+
+func (t *chainTracker) getLastSeen(ctx context.Context, key string) (*logstore_client.Cursor, error) {
+	if lastSeen, ok := t.lastSeen[key]; ok {
+		return lastSeen, nil
+	}
+
+	lastSeen, err := getStoredCursor(ctx, t.eventstore, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain position: %w", err)
+	}
+
+	t.lastSeen[key] = lastSeen
+	return lastSeen, nil
+}
+
+// chainKey builds the eventstore key of a chain. A publisher may use the same message chain id on every partition,
+// and these are different chains. Addresses may come checksummed or not
+func chainKey(streamId string, partition int, publisherId, msgChainId string) string {
+	return chainKeyPrefix + streamId + ":" + strconv.Itoa(partition) + ":" + strings.ToLower(publisherId) + ":" + msgChainId
+}
@@ -0,0 +1,44 @@
+package logstore_listener
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/kwilteam/kwil-db/extensions/listeners"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+)
+
+// getStoredCursor gets a cursor stored in the eventstore, or nil if there is none
+func getStoredCursor(ctx context.Context, eventstore listeners.EventStore, key []byte) (*logstore_client.Cursor, error) {
+	stored, err := eventstore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stored) == 0 {
+		return nil, nil
+	}
+
+	cursor, err := decodeCursor(stored)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+func encodeCursor(cursor logstore_client.Cursor) []byte {
+	bytes := make([]byte, 16)
+	binary.LittleEndian.PutUint64(bytes[:8], uint64(cursor.Timestamp))
+	binary.LittleEndian.PutUint64(bytes[8:], uint64(cursor.SequenceNumber))
+	return bytes
+}
+
+func decodeCursor(bytes []byte) (logstore_client.Cursor, error) {
+	if len(bytes) != 16 {
+		return logstore_client.Cursor{}, fmt.Errorf("invalid cursor of %d bytes", len(bytes))
+	}
+	return logstore_client.Cursor{
+		Timestamp:      int64(binary.LittleEndian.Uint64(bytes[:8])),
+		SequenceNumber: int(binary.LittleEndian.Uint64(bytes[8:])),
+	}, nil
+}
@@ -3,6 +3,7 @@ package logstore_listener
 import "github.com/gitploy-io/cronexpr"
 
 import (
+	"context"
 	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
 	"time"
 )
@@ -10,7 +11,7 @@ import (
 // LogStoreKeying is a keying service for the logstore listener.
 // it should implement the [paginated_poll_listener.KeyingService] interface.
 type LogStoreKeying struct {
-	client            logstore_client.LogStoreClient
+	client            *logstore_client.LogStoreClient
 	streamId          string
 	startingTimestamp *int64 // optional
 	cronExpr          cronexpr.Schedule
@@ -18,7 +19,7 @@ type LogStoreKeying struct {
 }
 
 type NewLogStoreKeyingOptions struct {
-	Client            logstore_client.LogStoreClient
+	Client            *logstore_client.LogStoreClient
 	StreamId          string
 	StartingTimestamp *int64
 	CronExprStr       string
@@ -41,19 +42,19 @@ func NewLogStoreKeying(options NewLogStoreKeyingOptions) *LogStoreKeying {
 }
 
 // GetStartingKey gets the starting key for the logstore listener.
-func (l *LogStoreKeying) GetStartingKey() (int64, error) {
+func (l *LogStoreKeying) GetStartingKey(ctx context.Context) (int64, error) {
 	// if starting timestamp is provided, return it
 	if l.startingTimestamp != nil {
 		return *l.startingTimestamp, nil
 	}
 	// else, we consider the first message timestamp in the stream
-	return l.client.GetFirstMessageTimestamp(l.streamId)
+	return l.client.GetFirstMessageTimestamp(ctx, l.streamId)
 }
 
 // GetCurrentKey gets the current key for the logstore listener.
 // it should return the current timestamp in UTC.
 // Overhead delay is added per configuration, so we can say that we only validate data that is at least overheadDelay old.
-func (l *LogStoreKeying) GetCurrentKey() (int64, error) {
+func (l *LogStoreKeying) GetCurrentKey(ctx context.Context) (int64, error) {
 	// let's return current timestamp in UTC from time
 	// alternatively we may switch it to timestamp in the future
 	// overhead delay is added per configuration
@@ -61,7 +62,7 @@ func (l *LogStoreKeying) GetCurrentKey() (int64, error) {
 }
 
 // GetKeyAfter gets the key after the given key for the logstore listener.
-func (l *LogStoreKeying) GetKeyAfter(key int64) (int64, error) {
+func (l *LogStoreKeying) GetKeyAfter(ctx context.Context, key int64) (int64, error) {
 	// convert from unix timestamp to time
 	keyTime := time.UnixMilli(key)
 
@@ -69,7 +70,7 @@ func (l *LogStoreKeying) GetKeyAfter(key int64) (int64, error) {
 }
 
 // GetKeyBefore gets the key before the given key for the logstore listener.
-func (l *LogStoreKeying) GetKeyBefore(key int64) (int64, error) {
+func (l *LogStoreKeying) GetKeyBefore(ctx context.Context, key int64) (int64, error) {
 	// convert from unix timestamp to time
 	keyTime := time.UnixMilli(key)
 
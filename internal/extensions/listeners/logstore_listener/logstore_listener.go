@@ -5,15 +5,18 @@ package logstore_listener
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/cenkalti/backoff"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/kwilteam/kwil-db/common"
-	"github.com/kwilteam/kwil-db/core/crypto"
 	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"github.com/kwilteam/kwil-db/core/log"
 	"github.com/kwilteam/kwil-db/extensions/listeners"
 	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
 	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
@@ -46,37 +49,142 @@ func Start(ctx context.Context, service *common.Service, eventstore listeners.Ev
 		return fmt.Errorf("failed to set config: %w", err)
 	}
 
-	privateKey, err := crypto.Secp256k1PrivateKeyFromHex(config.PrivateKey)
+	signer, err := config.newSigner()
 	if err != nil {
-		return fmt.Errorf("failed to parse private key: %w", err)
+		return fmt.Errorf("failed to create signer: %w", err)
 	}
-	signer := auth.EthPersonalSigner{
-		Key: *privateKey,
+	// in node_key mode the address is derived, so operators can't read it anywhere else
+	if config.SignerType == SignerNodeKey {
+		service.Logger.Info(fmt.Sprintf("authenticating to the log store as %s, derived from the kwild node key in %s", hexutil.Encode(signer.Identity()), config.NodeKeyFile))
+	} else {
+		service.Logger.Info(fmt.Sprintf("authenticating to the log store as %s", hexutil.Encode(signer.Identity())))
+	}
+
+	httpClient, err := logstore_client.NewHTTPClient(config.Transport)
+	if err != nil {
+		return fmt.Errorf("failed to create http client: %w", err)
+	}
+
+	clientOptions := []logstore_client.ClientOption{
+		logstore_client.WithHTTPClient(httpClient),
+		logstore_client.WithRetryPolicy(config.RetryPolicy),
+		logstore_client.WithRateLimit(config.RateLimit),
+		logstore_client.WithCrossCheck(config.CrossCheckNodes),
+		logstore_client.WithAuthRefreshInterval(config.AuthRefreshInterval),
+	}
+	clientOptions = append(clientOptions, logstore_client.WithPartitionCount(config.PartitionCount))
+	if config.Subscription {
+		clientOptions = append(clientOptions, logstore_client.WithSubscriptionEndpoint(config.SubscriptionEndpoint, config.SubscriptionApiKey))
+	}
+	if config.PartitionDiscovery {
+		registry, err := logstore_client.NewStreamRegistry(config.StreamRegistryRpcUrl, config.StreamRegistryAddress)
+		if err != nil {
+			return fmt.Errorf("failed to create stream registry: %w", err)
+		}
+		clientOptions = append(clientOptions, logstore_client.WithStreamRegistry(registry))
 	}
 
 	// create a new LogStoreClient
-	client := logstore_client.NewLogStoreClient(config.NodeEndpoint, signer)
+	client := logstore_client.NewLogStoreClient(config.NodeEndpoints, signer, clientOptions...)
 
-	// create a new LogStorePoller
-	poller := NewLogStorePoller(*client, config.StreamId)
+	if config.PartitionDiscovery {
+		partitionCount, err := client.DiscoverStreamPartitionCount(ctx, config.StreamId)
+		if err != nil {
+			service.Logger.Warn(fmt.Sprintf("failed to discover the partitions of stream %s, using partition_count = %d until it works: %v", config.StreamId, config.PartitionCount, err))
+		} else {
+			service.Logger.Info(fmt.Sprintf("stream %s has %d partitions", config.StreamId, partitionCount))
+		}
+	}
 
-	// every 1 minute
-	logStoreKeying := NewLogStoreKeying(NewLogStoreKeyingOptions{
-		OverheadDelay:     config.OverheadDelay,
-		StreamId:          config.StreamId,
-		Client:            *client,
-		StartingTimestamp: config.StartingTimestamp,
-		CronExprStr:       config.CronSchedule,
-	})
+	pollerOptions := NewLogStorePollerOptions{
+		Client:              client,
+		StreamId:            config.StreamId,
+		VerifySignatures:    config.VerifySignatures,
+		CanonicalizeContent: config.CanonicalizeContent,
+		MessageIdStrategy:   config.MessageIdStrategy,
+		EventStore:          eventstore,
+		GapRequeryAttempts:  config.ChainGapRequeryAttempts,
+		GapRequeryDelay:     config.ChainGapRequeryDelay,
+		PublisherFilter:     NewPublisherFilter(config.PublisherAllowlist, config.PublisherDenylist),
+		EncryptedPolicy:     config.EncryptedMessages,
+		GroupKeys:           config.GroupKeys,
+		Logger:              service.Logger,
+	}
+
+	err = checkKeyingMode(ctx, eventstore, config.KeyingMode)
+	if err != nil {
+		return err
+	}
+
+	var keyingService paginated_poll_listener.KeyingService
+	switch config.KeyingMode {
+	case KeyingModeBlock:
+		chain, err := logstore_client.NewChainReader(config.ChainRpcUrl)
+		if err != nil {
+			return fmt.Errorf("failed to create chain reader: %w", err)
+		}
+		defer chain.Close()
+
+		blockKeying := NewLogStoreBlockKeying(NewLogStoreBlockKeyingOptions{
+			Chain:         chain,
+			StartingBlock: config.StartingBlock,
+			BlockInterval: config.BlockInterval,
+			Confirmations: config.BlockConfirmations,
+		})
+		// keys are block heights, so the poller needs their timestamps to query the log store
+		pollerOptions.KeyTimestamps = blockKeying
+		keyingService = blockKeying
+	default:
+		// every 1 minute
+		keyingService = NewLogStoreKeying(NewLogStoreKeyingOptions{
+			OverheadDelay:     config.OverheadDelay,
+			StreamId:          config.StreamId,
+			Client:            client,
+			StartingTimestamp: config.StartingTimestamp,
+			CronExprStr:       config.CronSchedule,
+		})
+	}
+
+	// create a new LogStorePoller, which also listens to the stream live in subscription mode
+	var poller paginated_poll_listener.PollerService[*ingest_resolution.LogStoreIngestDataResolution]
+	if config.Subscription {
+		subscriptionPoller := NewLogStoreSubscriptionPoller(NewLogStoreSubscriptionPollerOptions{
+			NewLogStorePollerOptions: pollerOptions,
+			ReconcileAttempts:        config.SubscriptionReconcileAttempts,
+		})
+		go func() {
+			err := subscriptionPoller.Run(ctx)
+			if err != nil && ctx.Err() == nil {
+				service.Logger.Error(fmt.Sprintf("failed to subscribe to stream %s: %v", config.StreamId, err))
+			}
+		}()
+		poller = subscriptionPoller
+	} else {
+		poller = NewLogStorePoller(pollerOptions)
+	}
 
 	// update the ingest resolution with the lookup schemas
 	ingest_resolution.LogStoreIngestResolution.ContractSelectors = ingest_resolution.LookupSchemaToSelectors(config.LookupSchemas)
 
 	// create a new PaginatedPoller
 	paginatedPoller := paginated_poll_listener.PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{
-		PollerService:    poller,
-		KeyingService:    logStoreKeying,
-		IngestResolution: *ingest_resolution.LogStoreIngestResolution,
+		PollerService:            poller,
+		KeyingService:            keyingService,
+		IngestResolution:         *ingest_resolution.LogStoreIngestResolution,
+		DeadLetterPolicy:         config.DeadLetterPolicy,
+		BroadcastLedgerRetention: config.BroadcastLedgerRetention,
+	}
+
+	err = reviewDeadLetters(ctx, eventstore, config, service.Logger)
+	if err != nil {
+		return fmt.Errorf("failed to review dead letters: %w", err)
+	}
+
+	if config.AuditBroadcastsFrom != nil || config.AuditBroadcastsTo != nil {
+		err = auditBroadcasts(ctx, eventstore, config, service.Logger)
+		if err != nil {
+			return fmt.Errorf("failed to audit broadcasts: %w", err)
+		}
 	}
 
 	// When the log store node has just started, there's a chance that the node hasn't connected to
@@ -96,14 +204,27 @@ func Start(ctx context.Context, service *common.Service, eventstore listeners.Ev
 		// run indefinetely
 		expBackoff.MaxElapsedTime = 0
 
-		// inifity retrials, error is ignored here
-		_ = backoff.RetryNotify(func() error {
-			ready, err = client.IsPartitionReady(config.StreamId, 0)
+		// inifity retrials, error is ignored here, unless the context is done or we are not authorized
+		err = backoff.RetryNotify(func() error {
+			ready, err = client.IsPartitionReady(ctx, config.StreamId, 0)
+			// retrying won't fix the credentials
+			if errors.Is(err, logstore_client.ErrUnauthorized) {
+				return backoff.Permanent(err)
+			}
 			return err
-		}, expBackoff, func(err error, d time.Duration) {
+		}, backoff.WithContext(expBackoff, ctx), func(err error, d time.Duration) {
 			service.Logger.Warn(fmt.Sprintf("failed to connect to LS Node readiness check: %v, retrying in %v", err, d))
 		})
 
+		// the node is shutting down, so we don't need to wait for the stream anymore
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if errors.Is(err, logstore_client.ErrUnauthorized) {
+			return fmt.Errorf("not authorized to query stream %s: %w", config.StreamId, err)
+		}
+
 		if ready {
 			service.Logger.Info(fmt.Sprintf("stream %s is ready", config.StreamId))
 			break
@@ -127,22 +248,95 @@ func Start(ctx context.Context, service *common.Service, eventstore listeners.Ev
 			return nil
 		case <-time.After(5 * time.Second):
 			err = paginatedPoller.Run(ctx, service, eventstore)
-			if err != nil {
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrWindowNotStored):
+				// the window is retried on the next run
+				service.Logger.Info(fmt.Sprintf("holding back window: %v", err))
+			// these won't go away by themselves, so they need the operator attention
+			case errors.Is(err, logstore_client.ErrUnauthorized), errors.Is(err, logstore_client.ErrStreamNotFound):
+				service.Logger.Error(fmt.Sprintf("failed to run paginated poller, please check the configuration: %v", err))
+			default:
 				service.Logger.Warn(fmt.Sprintf("failed to run paginated poller: %v", err))
 			}
 		}
 	}
 }
 
+const (
+	// KeyingModeTimestamp closes windows following the cron schedule, using the local clock
+	KeyingModeTimestamp = "timestamp"
+	// KeyingModeBlock closes windows every block_interval blocks processed by the log store node
+	KeyingModeBlock = "block"
+)
+
 type LogStoreListenerConfig struct {
-	StreamId     string `json:"stream_id"`
-	NodeEndpoint string `json:"node_endpoint"`
+	StreamId string `json:"stream_id"`
+	// comma separated list of log store nodes, tried in order when one fails
+	NodeEndpoints []string `json:"node_endpoint"`
+	// number of nodes that must agree on each window. Defaults to 1, which disables cross-checking
+	CrossCheckNodes int `json:"cross_check_nodes"`
 	// defaults to 1 minute
 	OverheadDelay     time.Duration `json:"overhead_delay"`
 	StartingTimestamp *int64        `json:"starting_timestamp"`
 	CronSchedule      string        `json:"cron_schedule"`
-	PrivateKey        string        `json:"private_key"`
-	LookupSchemas     []string      `json:"lookup_schemas"`
+	// timestamp (default) or block
+	KeyingMode         string `json:"keying_mode"`
+	StartingBlock      *int64 `json:"starting_block"`
+	BlockInterval      int64  `json:"block_interval"`
+	BlockConfirmations int64  `json:"block_confirmations"`
+	ChainRpcUrl        string `json:"chain_rpc_url"`
+	// private_key (default), keystore, env or node_key. See [LogStoreListenerConfig.newSigner]
+	SignerType           SignerType    `json:"signer"`
+	PrivateKey           string        `json:"private_key"`
+	KeystoreFile         string        `json:"keystore_file"`
+	KeystorePasswordFile string        `json:"keystore_password_file"`
+	PrivateKeyEnv        string        `json:"private_key_env"`
+	NodeKeyFile          string        `json:"node_key_file"`
+	AuthRefreshInterval  time.Duration `json:"auth_refresh_interval"`
+	LookupSchemas        []string      `json:"lookup_schemas"`
+	// drops messages not signed by their publisher. Disabled by default
+	VerifySignatures bool `json:"verify_signatures"`
+	// re-encodes JSON contents with sorted keys and no whitespace. Disabled by default, so contents are ingested as published
+	CanonicalizeContent bool `json:"canonicalize_content"`
+	// message_ref (default), content_hash, or legacy, for deployments that already ingested messages with the old ids
+	MessageIdStrategy MessageIdStrategy `json:"message_id_strategy"`
+	// windows with message chain gaps are queried again this many times before being broadcast. Disabled by default
+	ChainGapRequeryAttempts int           `json:"chain_gap_requery_attempts"`
+	ChainGapRequeryDelay    time.Duration `json:"chain_gap_requery_delay"`
+	// subscribes to the stream, so windows are held back until the node stored what was received live. Disabled by default
+	Subscription                  bool `json:"subscription"`
+	SubscriptionReconcileAttempts int  `json:"subscription_reconcile_attempts"`
+	// the websocket plugin of the Streamr node messages are received live from, required by subscription
+	SubscriptionEndpoint string `json:"subscription_endpoint"`
+	SubscriptionApiKey   string `json:"-"`
+	// comma separated publisher addresses. If the allowlist is set, only its publishers are ingested
+	PublisherAllowlist []string `json:"publisher_allowlist"`
+	PublisherDenylist  []string `json:"publisher_denylist"`
+	// skip (default), decrypt or mark. Group keys are in the `<groupKeyId>:<hex key>,...` format
+	EncryptedMessages EncryptedMessagesPolicy   `json:"encrypted_messages"`
+	GroupKeys         logstore_client.GroupKeys `json:"-"`
+	// number of partitions of the stream, 1 by default. With partition discovery, it's only used while discovery fails
+	PartitionCount int `json:"partition_count"`
+	// reads the partition count from the stream registry. Disabled by default, it requires stream_registry_rpc_url
+	PartitionDiscovery    bool   `json:"partition_discovery"`
+	StreamRegistryRpcUrl  string `json:"stream_registry_rpc_url"`
+	StreamRegistryAddress string `json:"stream_registry_address"`
+	// request_timeout, tls_ca_file, tls_cert_file, tls_key_file and proxy_url
+	Transport logstore_client.TransportOptions `json:"-"`
+	// retry_max_attempts, retry_initial_interval and retry_max_interval. Disabled by default
+	RetryPolicy logstore_client.RetryPolicy `json:"-"`
+	RateLimit   logstore_client.RateLimit   `json:"-"`
+	// dead_letter_initial_interval and dead_letter_max_interval, the backoff of resolutions that failed to broadcast
+	DeadLetterPolicy paginated_poll_listener.DeadLetterPolicy `json:"-"`
+	// "all" or comma separated ids of dead letters to delete on start, giving up on broadcasting them
+	DeadLetterPurgeAll bool     `json:"-"`
+	DeadLetterPurgeIds []uint64 `json:"-"`
+	// logs the resolutions broadcast by this node for the windows starting in the range, on start
+	AuditBroadcastsFrom *int64 `json:"audit_broadcasts_from"`
+	AuditBroadcastsTo   *int64 `json:"audit_broadcasts_to"`
+	// number of windows kept in the broadcast ledger, the older ones are pruned
+	BroadcastLedgerRetention int `json:"broadcast_ledger_retention"`
 }
 
 func (c *LogStoreListenerConfig) setConfig(config map[string]string) error {
@@ -156,7 +350,33 @@ func (c *LogStoreListenerConfig) setConfig(config map[string]string) error {
 	if !ok {
 		return fmt.Errorf("missing nodeEndpoint")
 	}
-	c.NodeEndpoint = nodeEndpoint
+	c.NodeEndpoints = nil
+	for _, endpoint := range strings.Split(nodeEndpoint, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint != "" {
+			c.NodeEndpoints = append(c.NodeEndpoints, endpoint)
+		}
+	}
+	if len(c.NodeEndpoints) == 0 {
+		return fmt.Errorf("missing nodeEndpoint")
+	}
+
+	crossCheckNodes, ok := config["cross_check_nodes"]
+	if !ok {
+		c.CrossCheckNodes = 1
+	} else {
+		crossCheckNodesInt, err := strconv.Atoi(crossCheckNodes)
+		if err != nil {
+			return fmt.Errorf("failed to parse cross_check_nodes: %w", err)
+		}
+		if crossCheckNodesInt < 1 {
+			return fmt.Errorf("cross_check_nodes must be at least 1, got %d", crossCheckNodesInt)
+		}
+		if crossCheckNodesInt > len(c.NodeEndpoints) {
+			return fmt.Errorf("cross_check_nodes is %d, but only %d endpoints are configured", crossCheckNodesInt, len(c.NodeEndpoints))
+		}
+		c.CrossCheckNodes = crossCheckNodesInt
+	}
 
 	overheadDelay, ok := config["overhead_delay"]
 	if !ok {
@@ -169,12 +389,28 @@ func (c *LogStoreListenerConfig) setConfig(config map[string]string) error {
 		c.OverheadDelay = overheadDelayDuration
 	}
 
-	cronSchedule, ok := config["cron_schedule"]
+	keyingMode, ok := config["keying_mode"]
 	if !ok {
-		return fmt.Errorf("missing cronSchedule")
+		keyingMode = KeyingModeTimestamp
 	}
+	c.KeyingMode = keyingMode
 
-	c.CronSchedule = cronSchedule
+	switch c.KeyingMode {
+	case KeyingModeTimestamp:
+		cronSchedule, ok := config["cron_schedule"]
+		if !ok {
+			return fmt.Errorf("missing cronSchedule")
+		}
+
+		c.CronSchedule = cronSchedule
+	case KeyingModeBlock:
+		err := c.setBlockKeyingConfig(config)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown keying_mode: %s", c.KeyingMode)
+	}
 
 	startingTimestamp, ok := config["starting_timestamp"]
 	if !ok {
@@ -187,11 +423,21 @@ func (c *LogStoreListenerConfig) setConfig(config map[string]string) error {
 		c.StartingTimestamp = &startingTimestampInt
 	}
 
-	privateKey, ok := config["private_key"]
+	err := c.setSignerConfig(config)
+	if err != nil {
+		return err
+	}
+
+	authRefreshInterval, ok := config["auth_refresh_interval"]
 	if !ok {
-		return fmt.Errorf("missing private_key")
+		c.AuthRefreshInterval = logstore_client.DefaultAuthRefreshInterval
+	} else {
+		authRefreshIntervalDuration, err := time.ParseDuration(authRefreshInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse auth_refresh_interval: %w", err)
+		}
+		c.AuthRefreshInterval = authRefreshIntervalDuration
 	}
-	c.PrivateKey = privateKey
 
 	lookupSchemas, ok := config["lookup_schemas"]
 	if !ok {
@@ -199,5 +445,472 @@ func (c *LogStoreListenerConfig) setConfig(config map[string]string) error {
 	}
 	c.LookupSchemas = strings.Split(lookupSchemas, ",")
 
+	verifySignatures, ok := config["verify_signatures"]
+	if !ok {
+		c.VerifySignatures = false
+	} else {
+		verifySignaturesBool, err := strconv.ParseBool(verifySignatures)
+		if err != nil {
+			return fmt.Errorf("failed to parse verify_signatures: %w", err)
+		}
+		c.VerifySignatures = verifySignaturesBool
+	}
+
+	canonicalizeContent, ok := config["canonicalize_content"]
+	if !ok {
+		c.CanonicalizeContent = false
+	} else {
+		canonicalizeContentBool, err := strconv.ParseBool(canonicalizeContent)
+		if err != nil {
+			return fmt.Errorf("failed to parse canonicalize_content: %w", err)
+		}
+		c.CanonicalizeContent = canonicalizeContentBool
+	}
+
+	messageIdStrategy, err := ParseMessageIdStrategy(config["message_id_strategy"])
+	if err != nil {
+		return err
+	}
+	c.MessageIdStrategy = messageIdStrategy
+
+	chainGapRequeryAttempts, ok := config["chain_gap_requery_attempts"]
+	if !ok {
+		c.ChainGapRequeryAttempts = 0
+	} else {
+		chainGapRequeryAttemptsInt, err := strconv.Atoi(chainGapRequeryAttempts)
+		if err != nil {
+			return fmt.Errorf("failed to parse chain_gap_requery_attempts: %w", err)
+		}
+		c.ChainGapRequeryAttempts = chainGapRequeryAttemptsInt
+	}
+
+	chainGapRequeryDelay, ok := config["chain_gap_requery_delay"]
+	if !ok {
+		c.ChainGapRequeryDelay = 10 * time.Second
+	} else {
+		chainGapRequeryDelayDuration, err := time.ParseDuration(chainGapRequeryDelay)
+		if err != nil {
+			return fmt.Errorf("failed to parse chain_gap_requery_delay: %w", err)
+		}
+		c.ChainGapRequeryDelay = chainGapRequeryDelayDuration
+	}
+
+	subscription, ok := config["subscription"]
+	if !ok {
+		c.Subscription = false
+	} else {
+		subscriptionBool, err := strconv.ParseBool(subscription)
+		if err != nil {
+			return fmt.Errorf("failed to parse subscription: %w", err)
+		}
+		c.Subscription = subscriptionBool
+	}
+
+	subscriptionReconcileAttempts, ok := config["subscription_reconcile_attempts"]
+	if !ok {
+		c.SubscriptionReconcileAttempts = DefaultReconcileAttempts
+	} else {
+		subscriptionReconcileAttemptsInt, err := strconv.Atoi(subscriptionReconcileAttempts)
+		if err != nil {
+			return fmt.Errorf("failed to parse subscription_reconcile_attempts: %w", err)
+		}
+		c.SubscriptionReconcileAttempts = subscriptionReconcileAttemptsInt
+	}
+
+	c.SubscriptionEndpoint = config["subscription_endpoint"]
+	if c.Subscription && c.SubscriptionEndpoint == "" {
+		return fmt.Errorf("missing subscription_endpoint, required by subscription")
+	}
+	c.SubscriptionApiKey = config["subscription_api_key"]
+
+	c.PublisherAllowlist = nil
+	if publisherAllowlist, ok := config["publisher_allowlist"]; ok {
+		c.PublisherAllowlist = strings.Split(publisherAllowlist, ",")
+	}
+
+	c.PublisherDenylist = nil
+	if publisherDenylist, ok := config["publisher_denylist"]; ok {
+		c.PublisherDenylist = strings.Split(publisherDenylist, ",")
+	}
+
+	encryptedMessages, ok := config["encrypted_messages"]
+	if !ok {
+		encryptedMessages = string(EncryptedMessagesSkip)
+	}
+	c.EncryptedMessages = EncryptedMessagesPolicy(encryptedMessages)
+	switch c.EncryptedMessages {
+	case EncryptedMessagesSkip, EncryptedMessagesMark:
+	case EncryptedMessagesDecrypt:
+		groupKeys, err := logstore_client.ParseGroupKeys(config["group_keys"])
+		if err != nil {
+			return fmt.Errorf("failed to parse group_keys: %w", err)
+		}
+		if len(groupKeys) == 0 {
+			return fmt.Errorf("missing group_keys to decrypt messages")
+		}
+		c.GroupKeys = groupKeys
+	default:
+		return fmt.Errorf("unknown encrypted_messages: %s", encryptedMessages)
+	}
+
+	partitionCount, ok := config["partition_count"]
+	if !ok {
+		c.PartitionCount = 1
+	} else {
+		partitionCountInt, err := strconv.Atoi(partitionCount)
+		if err != nil {
+			return fmt.Errorf("failed to parse partition_count: %w", err)
+		}
+		if partitionCountInt < 1 {
+			return fmt.Errorf("partition_count must be at least 1")
+		}
+		c.PartitionCount = partitionCountInt
+	}
+
+	partitionDiscovery, ok := config["partition_discovery"]
+	if !ok {
+		c.PartitionDiscovery = false
+	} else {
+		partitionDiscoveryBool, err := strconv.ParseBool(partitionDiscovery)
+		if err != nil {
+			return fmt.Errorf("failed to parse partition_discovery: %w", err)
+		}
+		c.PartitionDiscovery = partitionDiscoveryBool
+	}
+
+	c.StreamRegistryRpcUrl = config["stream_registry_rpc_url"]
+	if c.PartitionDiscovery && c.StreamRegistryRpcUrl == "" {
+		return fmt.Errorf("missing stream_registry_rpc_url, required by partition_discovery")
+	}
+
+	streamRegistryAddress, ok := config["stream_registry_address"]
+	if !ok {
+		streamRegistryAddress = logstore_client.DefaultStreamRegistryAddress
+	}
+	c.StreamRegistryAddress = streamRegistryAddress
+
+	requestTimeout, ok := config["request_timeout"]
+	if !ok {
+		c.Transport.Timeout = time.Minute
+	} else {
+		requestTimeoutDuration, err := time.ParseDuration(requestTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to parse request_timeout: %w", err)
+		}
+		c.Transport.Timeout = requestTimeoutDuration
+	}
+
+	c.Transport.CAFile = config["tls_ca_file"]
+	c.Transport.CertFile = config["tls_cert_file"]
+	c.Transport.KeyFile = config["tls_key_file"]
+	c.Transport.ProxyURL = config["proxy_url"]
+
+	retryMaxAttempts, ok := config["retry_max_attempts"]
+	if !ok {
+		c.RetryPolicy.MaxAttempts = 1
+	} else {
+		retryMaxAttemptsInt, err := strconv.Atoi(retryMaxAttempts)
+		if err != nil {
+			return fmt.Errorf("failed to parse retry_max_attempts: %w", err)
+		}
+		c.RetryPolicy.MaxAttempts = retryMaxAttemptsInt
+	}
+
+	retryInitialInterval, ok := config["retry_initial_interval"]
+	if ok {
+		retryInitialIntervalDuration, err := time.ParseDuration(retryInitialInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse retry_initial_interval: %w", err)
+		}
+		c.RetryPolicy.InitialInterval = retryInitialIntervalDuration
+	}
+
+	retryMaxInterval, ok := config["retry_max_interval"]
+	if ok {
+		retryMaxIntervalDuration, err := time.ParseDuration(retryMaxInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse retry_max_interval: %w", err)
+		}
+		c.RetryPolicy.MaxInterval = retryMaxIntervalDuration
+	}
+
+	rateLimit, ok := config["rate_limit"]
+	if ok {
+		rateLimitFloat, err := strconv.ParseFloat(rateLimit, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse rate_limit: %w", err)
+		}
+		if rateLimitFloat < 0 {
+			return fmt.Errorf("rate_limit can't be negative, got %v", rateLimitFloat)
+		}
+		c.RateLimit.RequestsPerSecond = rateLimitFloat
+	}
+
+	rateLimitBurst, ok := config["rate_limit_burst"]
+	if ok {
+		rateLimitBurstInt, err := strconv.Atoi(rateLimitBurst)
+		if err != nil {
+			return fmt.Errorf("failed to parse rate_limit_burst: %w", err)
+		}
+		if rateLimitBurstInt < 0 {
+			return fmt.Errorf("rate_limit_burst can't be negative, got %d", rateLimitBurstInt)
+		}
+		c.RateLimit.Burst = rateLimitBurstInt
+	}
+
+	maxConcurrentRequests, ok := config["max_concurrent_requests"]
+	if ok {
+		maxConcurrentRequestsInt, err := strconv.Atoi(maxConcurrentRequests)
+		if err != nil {
+			return fmt.Errorf("failed to parse max_concurrent_requests: %w", err)
+		}
+		if maxConcurrentRequestsInt < 0 {
+			return fmt.Errorf("max_concurrent_requests can't be negative, got %d", maxConcurrentRequestsInt)
+		}
+		c.RateLimit.MaxConcurrency = maxConcurrentRequestsInt
+	}
+
+	deadLetterInitialInterval, ok := config["dead_letter_initial_interval"]
+	if ok {
+		deadLetterInitialIntervalDuration, err := time.ParseDuration(deadLetterInitialInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse dead_letter_initial_interval: %w", err)
+		}
+		c.DeadLetterPolicy.InitialInterval = deadLetterInitialIntervalDuration
+	}
+
+	deadLetterMaxInterval, ok := config["dead_letter_max_interval"]
+	if ok {
+		deadLetterMaxIntervalDuration, err := time.ParseDuration(deadLetterMaxInterval)
+		if err != nil {
+			return fmt.Errorf("failed to parse dead_letter_max_interval: %w", err)
+		}
+		c.DeadLetterPolicy.MaxInterval = deadLetterMaxIntervalDuration
+	}
+
+	c.DeadLetterPurgeAll = false
+	c.DeadLetterPurgeIds = nil
+	deadLetterPurge := strings.TrimSpace(config["dead_letter_purge"])
+	if deadLetterPurge == "all" {
+		c.DeadLetterPurgeAll = true
+	} else if deadLetterPurge != "" {
+		for _, id := range strings.Split(deadLetterPurge, ",") {
+			idInt, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse dead_letter_purge: %w", err)
+			}
+			c.DeadLetterPurgeIds = append(c.DeadLetterPurgeIds, idInt)
+		}
+	}
+
+	c.AuditBroadcastsFrom = nil
+	auditBroadcastsFrom, ok := config["audit_broadcasts_from"]
+	if ok {
+		auditBroadcastsFromInt, err := strconv.ParseInt(auditBroadcastsFrom, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse audit_broadcasts_from: %w", err)
+		}
+		c.AuditBroadcastsFrom = &auditBroadcastsFromInt
+	}
+
+	c.AuditBroadcastsTo = nil
+	auditBroadcastsTo, ok := config["audit_broadcasts_to"]
+	if ok {
+		auditBroadcastsToInt, err := strconv.ParseInt(auditBroadcastsTo, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse audit_broadcasts_to: %w", err)
+		}
+		c.AuditBroadcastsTo = &auditBroadcastsToInt
+	}
+
+	broadcastLedgerRetention, ok := config["broadcast_ledger_retention"]
+	if !ok {
+		c.BroadcastLedgerRetention = paginated_poll_listener.DefaultBroadcastLedgerRetention
+	} else {
+		broadcastLedgerRetentionInt, err := strconv.Atoi(broadcastLedgerRetention)
+		if err != nil {
+			return fmt.Errorf("failed to parse broadcast_ledger_retention: %w", err)
+		}
+		if broadcastLedgerRetentionInt < 1 {
+			return fmt.Errorf("broadcast_ledger_retention must be at least 1, got %d", broadcastLedgerRetentionInt)
+		}
+		c.BroadcastLedgerRetention = broadcastLedgerRetentionInt
+	}
+
+	return nil
+}
+
+func (c *LogStoreListenerConfig) setBlockKeyingConfig(config map[string]string) error {
+	blockInterval, ok := config["block_interval"]
+	if !ok {
+		return fmt.Errorf("missing block_interval")
+	}
+	blockIntervalInt, err := strconv.ParseInt(blockInterval, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse block_interval: %w", err)
+	}
+	if blockIntervalInt < 1 {
+		return fmt.Errorf("block_interval must be at least 1")
+	}
+	c.BlockInterval = blockIntervalInt
+
+	blockConfirmations, ok := config["block_confirmations"]
+	if !ok {
+		c.BlockConfirmations = 0
+	} else {
+		blockConfirmationsInt, err := strconv.ParseInt(blockConfirmations, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse block_confirmations: %w", err)
+		}
+		c.BlockConfirmations = blockConfirmationsInt
+	}
+
+	startingBlock, ok := config["starting_block"]
+	if !ok {
+		c.StartingBlock = nil
+	} else {
+		startingBlockInt, err := strconv.ParseInt(startingBlock, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse starting_block: %w", err)
+		}
+		c.StartingBlock = &startingBlockInt
+	}
+
+	chainRpcUrl, ok := config["chain_rpc_url"]
+	if !ok {
+		return fmt.Errorf("missing chain_rpc_url")
+	}
+	c.ChainRpcUrl = chainRpcUrl
+
+	return nil
+}
+
+type SignerType string
+
+const (
+	// SignerPrivateKey uses the hex private key in private_key
+	SignerPrivateKey SignerType = "private_key"
+	// SignerKeystore decrypts the geth keystore in keystore_file with the password in keystore_password_file
+	SignerKeystore SignerType = "keystore"
+	// SignerEnv uses the hex private key in the private_key_env environment variable
+	SignerEnv SignerType = "env"
+	// SignerNodeKey derives the key from the kwild node key in node_key_file
+	SignerNodeKey SignerType = "node_key"
+)
+
+// setSignerConfig sets the signer config, so plaintext keys don't need to be in the config file
+func (c *LogStoreListenerConfig) setSignerConfig(config map[string]string) error {
+	signerType, ok := config["signer"]
+	if !ok {
+		signerType = string(SignerPrivateKey)
+	}
+	c.SignerType = SignerType(signerType)
+
+	switch c.SignerType {
+	case SignerPrivateKey:
+		privateKey, ok := config["private_key"]
+		if !ok {
+			return fmt.Errorf("missing private_key")
+		}
+		c.PrivateKey = privateKey
+	case SignerKeystore:
+		keystoreFile, ok := config["keystore_file"]
+		if !ok {
+			return fmt.Errorf("missing keystore_file")
+		}
+		c.KeystoreFile = keystoreFile
+
+		keystorePasswordFile, ok := config["keystore_password_file"]
+		if !ok {
+			return fmt.Errorf("missing keystore_password_file")
+		}
+		c.KeystorePasswordFile = keystorePasswordFile
+	case SignerEnv:
+		privateKeyEnv, ok := config["private_key_env"]
+		if !ok {
+			privateKeyEnv = logstore_client.DefaultPrivateKeyEnv
+		}
+		c.PrivateKeyEnv = privateKeyEnv
+	case SignerNodeKey:
+		nodeKeyFile, ok := config["node_key_file"]
+		if !ok {
+			return fmt.Errorf("missing node_key_file")
+		}
+		c.NodeKeyFile = nodeKeyFile
+	default:
+		return fmt.Errorf("unknown signer: %s", signerType)
+	}
+
+	return nil
+}
+
+// newSigner creates the signer used to authenticate to the log store node
+func (c *LogStoreListenerConfig) newSigner() (auth.Signer, error) {
+	switch c.SignerType {
+	case SignerKeystore:
+		return logstore_client.NewKeystoreSigner(c.KeystoreFile, c.KeystorePasswordFile)
+	case SignerEnv:
+		return logstore_client.NewEnvSigner(c.PrivateKeyEnv)
+	case SignerNodeKey:
+		return logstore_client.NewNodeKeySigner(c.NodeKeyFile)
+	default:
+		return logstore_client.NewPrivateKeySigner(c.PrivateKey)
+	}
+}
+
+// reviewDeadLetters purges the dead letters selected by the configuration, once, and logs the ones left,
+// so operators can decide what to purge on the next start
+func reviewDeadLetters(ctx context.Context, eventstore listeners.EventStore, config LogStoreListenerConfig, logger log.SugaredLogger) error {
+	if config.DeadLetterPurgeAll || len(config.DeadLetterPurgeIds) > 0 {
+		purged, ran, err := paginated_poll_listener.PurgeDeadLettersOnce(ctx, eventstore, config.DeadLetterPurgeIds...)
+		if err != nil {
+			return err
+		}
+		if ran {
+			logger.Warn(fmt.Sprintf("purged %d dead letters, their messages won't be ingested", purged))
+		} else {
+			logger.Info("dead_letter_purge already ran, it can be removed from the configuration")
+		}
+	} else {
+		// once the purge is removed from the configuration, it can be requested again
+		err := paginated_poll_listener.ForgetDeadLetterPurge(ctx, eventstore)
+		if err != nil {
+			return err
+		}
+	}
+
+	deadLetters, err := paginated_poll_listener.ListDeadLetters(ctx, eventstore)
+	if err != nil {
+		return err
+	}
+	for _, deadLetter := range deadLetters {
+		logger.Warn(fmt.Sprintf("dead letter %d from %d to %d: %d attempts since %s, next at %s, last error: %s",
+			deadLetter.Id, deadLetter.From, deadLetter.To, deadLetter.Attempts,
+			deadLetter.CreatedAt.Format(time.RFC3339), deadLetter.NextRetry.Format(time.RFC3339), deadLetter.LastError))
+	}
+	return nil
+}
+
+// auditBroadcasts logs the resolutions this node broadcast for the configured windows
+func auditBroadcasts(ctx context.Context, eventstore listeners.EventStore, config LogStoreListenerConfig, logger log.SugaredLogger) error {
+	from, to := int64(0), int64(math.MaxInt64)
+	if config.AuditBroadcastsFrom != nil {
+		from = *config.AuditBroadcastsFrom
+	}
+	if config.AuditBroadcastsTo != nil {
+		to = *config.AuditBroadcastsTo
+	}
+
+	records, err := paginated_poll_listener.ListBroadcasts(ctx, eventstore, from, to)
+	if err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("%d resolutions broadcast for the windows from %d to %d", len(records), from, to))
+	for _, record := range records {
+		logger.Info(fmt.Sprintf("broadcast %d: %s from %d to %d at %s, %d bytes, hash %s",
+			record.Index, record.ResolutionName, record.From, record.To,
+			record.BroadcastAt.Format(time.RFC3339), record.Size, record.Hash))
+	}
 	return nil
 }
@@ -0,0 +1,68 @@
+package logstore_listener
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"strconv"
+	"strings"
+)
+
+// MessageIdStrategy says how the id of ingested messages is built
+type MessageIdStrategy string
+
+const (
+	// MessageIdLegacy is `<timestamp>_<sequenceNumber>_<partition>`.
+	// Messages of different publishers or message chains may collide, but it's the default, so the ids of
+	// existing deployments don't change. New deployments should pick one of the others.
+	MessageIdLegacy MessageIdStrategy = "legacy"
+	// MessageIdRef is the full message reference: `<timestamp>_<sequenceNumber>_<partition>_<publisherId>_<msgChainId>`
+	MessageIdRef MessageIdStrategy = "message_ref"
+	// MessageIdContentHash is the hex sha256 of the full message reference and the ingested content,
+	// which has a fixed length, and changes if the content does
+	MessageIdContentHash MessageIdStrategy = "content_hash"
+)
+
+// DefaultMessageIdStrategy is used if no strategy is configured
+const DefaultMessageIdStrategy = MessageIdLegacy
+
+// ParseMessageIdStrategy parses a strategy, using the default one if it's empty
+func ParseMessageIdStrategy(value string) (MessageIdStrategy, error) {
+	switch strategy := MessageIdStrategy(value); strategy {
+	case "":
+		return DefaultMessageIdStrategy, nil
+	case MessageIdLegacy, MessageIdRef, MessageIdContentHash:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown message id strategy: %s", value)
+	}
+}
+
+// messageId builds the id of a message with the given strategy.
+// The content is the one being ingested, as encoded in the resolution.
+func messageId(strategy MessageIdStrategy, message *logstore_client.JSONStreamMessage, content string) string {
+	legacyComponents := []string{
+		strconv.Itoa(int(message.Timestamp)),
+		strconv.Itoa(message.SequenceNumber),
+		strconv.Itoa(message.StreamPartition),
+	}
+	// addresses may come checksummed or not, and the id must be the same for every validator
+	refComponents := append(legacyComponents, strings.ToLower(message.PublisherId), message.MsgChainId)
+
+	switch strategy {
+	case MessageIdLegacy:
+		return strings.Join(legacyComponents, "_")
+	case MessageIdContentHash:
+		hash := sha256.New()
+		// the stream id is included, so ids don't collide across streams ingested into the same table
+		for _, component := range append([]string{message.StreamId}, refComponents...) {
+			hash.Write([]byte(component))
+			hash.Write([]byte{0})
+		}
+		hash.Write([]byte(content))
+		return hex.EncodeToString(hash.Sum(nil))
+	default:
+		return strings.Join(refComponents, "_")
+	}
+}
@@ -0,0 +1,67 @@
+package logstore_listener
+
+import (
+	"testing"
+
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"gotest.tools/assert"
+)
+
+func Test_MessageId(t *testing.T) {
+	message := logstore_client.JSONStreamMessage{
+		StreamId:        "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo",
+		StreamPartition: 1,
+		Timestamp:       1713966000000,
+		SequenceNumber:  2,
+		PublisherId:     "0xD37dC4D7e2c1BDF3eDD89DB0E505394ea69aF43D",
+		MsgChainId:      "chain",
+	}
+	// the same message, as another node may send it
+	lowercased := message
+	lowercased.PublisherId = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d"
+	// another message with the same reference, but from another publisher
+	otherPublisher := message
+	otherPublisher.PublisherId = "0x00000000000000000000000000000000000000aa"
+
+	tests := []struct {
+		name     string
+		strategy MessageIdStrategy
+		want     string
+		// tells if messages of different publishers get different ids
+		unique bool
+	}{
+		{name: "Legacy", strategy: MessageIdLegacy, want: "1713966000000_2_1"},
+		{name: "Message ref", strategy: MessageIdRef, want: "1713966000000_2_1_0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d_chain", unique: true},
+		// pinned, so a change in the hash is noticed before it changes the ids of a deployment
+		{name: "Content hash", strategy: MessageIdContentHash, want: "455cd8ca661a4be115472f779e1282e9045aac627ce74dc0f64874e6107ad88d", unique: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := messageId(tt.strategy, &message, `{"n":1}`)
+			assert.Equal(t, id, tt.want)
+			assert.Equal(t, messageId(tt.strategy, &lowercased, `{"n":1}`), id)
+			assert.Equal(t, messageId(tt.strategy, &otherPublisher, `{"n":1}`) != id, tt.unique)
+		})
+	}
+
+	t.Run("Content hash changes with the content", func(t *testing.T) {
+		assert.Assert(t, messageId(MessageIdContentHash, &message, `{"n":2}`) != messageId(MessageIdContentHash, &message, `{"n":1}`))
+	})
+}
+
+func Test_ParseMessageIdStrategy(t *testing.T) {
+	// existing deployments don't configure a strategy, so their ids must not change
+	strategy, err := ParseMessageIdStrategy("")
+	assert.NilError(t, err)
+	assert.Equal(t, strategy, MessageIdLegacy)
+
+	for _, want := range []MessageIdStrategy{MessageIdLegacy, MessageIdRef, MessageIdContentHash} {
+		strategy, err := ParseMessageIdStrategy(string(want))
+		assert.NilError(t, err)
+		assert.Equal(t, strategy, want)
+	}
+
+	_, err = ParseMessageIdStrategy("uuid")
+	assert.ErrorContains(t, err, "unknown message id strategy")
+}
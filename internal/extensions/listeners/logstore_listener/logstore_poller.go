@@ -1,71 +1,344 @@
 package logstore_listener
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/kwilteam/kwil-db/extensions/listeners"
 	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
 	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
 	"github.com/usherlabs/kwil-ls-oracle/internal/paginated_poll_listener"
-	"strconv"
-	"strings"
+	"time"
 )
 
 // LogStorePoller is a poller service for the logstore listener.
 // it should implement the [paginated_poll_listener.PollerService] interface.
 type LogStorePoller struct {
-	client   logstore_client.LogStoreClient
-	streamId string
+	client           *logstore_client.LogStoreClient
+	streamId         string
+	keyTimestamps    KeyTimestampConverter // optional
+	verifySignatures bool
+	// canonicalizeContent re-encodes JSON contents with sorted keys and no whitespace
+	canonicalizeContent bool
+	messageIdStrategy   MessageIdStrategy
+	// eventstore keeps the last message seen on each message chain, to find gaps. Gaps aren't checked without it
+	eventstore         listeners.EventStore
+	gapRequeryAttempts int
+	gapRequeryDelay    time.Duration
+	// reconciler checks windows against the messages received live, in subscription mode
+	reconciler      windowReconciler // optional
+	publisherFilter *PublisherFilter // optional
+	encryptedPolicy EncryptedMessagesPolicy
+	groupKeys       logstore_client.GroupKeys
+	logger          log.SugaredLogger
+	// pending is the window last fetched, committed once it's broadcast
+	pending *pendingWindow
 }
 
+// pendingWindow holds the state of a fetched window until it's broadcast
+type pendingWindow struct {
+	fromKey, toKey int64
+	tracker        *chainTracker
+}
+
+// EncryptedMessagesPolicy says what to do with messages whose content is encrypted
+type EncryptedMessagesPolicy string
+
+const (
+	// EncryptedMessagesSkip drops encrypted messages
+	EncryptedMessagesSkip EncryptedMessagesPolicy = "skip"
+	// EncryptedMessagesDecrypt decrypts messages with the configured group keys, dropping the ones it can't decrypt
+	EncryptedMessagesDecrypt EncryptedMessagesPolicy = "decrypt"
+	// EncryptedMessagesMark ingests the ciphertext, with the encrypted marker set
+	EncryptedMessagesMark EncryptedMessagesPolicy = "mark"
+)
+
 var _ paginated_poll_listener.PollerService[*ingest_resolution.LogStoreIngestDataResolution] = (*LogStorePoller)(nil)
+var _ paginated_poll_listener.WindowCommitter = (*LogStorePoller)(nil)
+
+// windowReconciler checks a fetched window against another source of messages, before it's committed
+type windowReconciler interface {
+	// Observe sees every message received for the window
+	Observe(message *logstore_client.JSONStreamMessage)
+	// Reconcile rejects the window if the messages observed are not complete, and forgets them
+	Reconcile(from, to logstore_client.Cursor) error
+}
+
+// KeyTimestampConverter converts keys into timestamps, for keying services whose keys are not timestamps.
+type KeyTimestampConverter interface {
+	KeyToTimestamp(ctx context.Context, key int64) (int64, error)
+}
+
+type NewLogStorePollerOptions struct {
+	Client   *logstore_client.LogStoreClient
+	StreamId string
+	// KeyTimestamps is required if keys are not timestamps, such as with [LogStoreBlockKeying]
+	KeyTimestamps KeyTimestampConverter
+	// VerifySignatures drops messages that were not signed by their publisher
+	VerifySignatures bool
+	// CanonicalizeContent re-encodes JSON contents with sorted keys and no whitespace, so every validator ingests the same bytes
+	CanonicalizeContent bool
+	// MessageIdStrategy defaults to [DefaultMessageIdStrategy]
+	MessageIdStrategy MessageIdStrategy
+	// EventStore enables message chain gap detection
+	EventStore listeners.EventStore
+	// GapRequeryAttempts is how many times a window with gaps is queried again before being broadcast with them
+	GapRequeryAttempts int
+	GapRequeryDelay    time.Duration
+	// PublisherFilter drops messages from publishers that are not allowed
+	PublisherFilter *PublisherFilter
+	// EncryptedPolicy defaults to skipping encrypted messages. GroupKeys are used to decrypt them
+	EncryptedPolicy EncryptedMessagesPolicy
+	GroupKeys       logstore_client.GroupKeys
+	Logger          log.SugaredLogger
+}
 
-func NewLogStorePoller(client logstore_client.LogStoreClient, streamId string) *LogStorePoller {
-	return &LogStorePoller{client: client, streamId: streamId}
+func NewLogStorePoller(options NewLogStorePollerOptions) *LogStorePoller {
+	return &LogStorePoller{
+		client:              options.Client,
+		streamId:            options.StreamId,
+		keyTimestamps:       options.KeyTimestamps,
+		verifySignatures:    options.VerifySignatures,
+		canonicalizeContent: options.CanonicalizeContent,
+		messageIdStrategy:   options.MessageIdStrategy,
+		eventstore:          options.EventStore,
+		gapRequeryAttempts:  options.GapRequeryAttempts,
+		gapRequeryDelay:     options.GapRequeryDelay,
+		publisherFilter:     options.PublisherFilter,
+		encryptedPolicy:     options.EncryptedPolicy,
+		groupKeys:           options.GroupKeys,
+		logger:              options.Logger,
+	}
 }
 
 // GetData gets the data from the service from the given key range. FROM (inclusive) and TO (exclusive)
-func (l *LogStorePoller) GetData(from, to int64) (**ingest_resolution.LogStoreIngestDataResolution, error) {
-	messages, err := l.client.QueryAllPartitions(l.streamId, from, to-1)
+func (l *LogStorePoller) GetData(ctx context.Context, fromKey, toKey int64) (**ingest_resolution.LogStoreIngestDataResolution, error) {
+	// a window that wasn't committed failed to be processed, its state is dropped
+	l.pending = nil
 
+	fromTimestamp, toTimestamp, err := l.keysToTimestamps(ctx, fromKey, toKey)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to convert keys to timestamps: %w", err)
+	}
+
+	from := logstore_client.StartOf(fromTimestamp)
+	to := logstore_client.StartOf(toTimestamp).Previous()
+
+	// a window is always fetched whole. Windows fetched again after a restart, because their progress wasn't stored,
+	// produce the same resolution, which the broadcast ledger doesn't broadcast twice
+
+	for attempt := 0; ; attempt++ {
+		var tracker *chainTracker
+		if l.eventstore != nil {
+			tracker = newChainTracker(l.eventstore)
+		}
+
+		ingestMessages, err := l.fetchWindow(ctx, from, to, tracker)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.reconciler != nil {
+			err = l.reconciler.Reconcile(from, to)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if tracker != nil {
+			gaps := tracker.Gaps()
+			if len(gaps) > 0 && attempt < l.gapRequeryAttempts {
+				// the log store node may still be receiving the missing messages from other nodes
+				l.logger.Info(fmt.Sprintf("found %d message chain gaps from %s to %s, querying the window again in %s", len(gaps), from, to, l.gapRequeryDelay))
+				chainGapRequeriesMetric.Add(1)
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(l.gapRequeryDelay):
+				}
+				continue
+			}
+
+			for _, gap := range gaps {
+				l.logger.Warn(fmt.Sprintf("message chain gap from %s to %s: %s", from, to, gap))
+			}
+			chainGapsMetric.Add(int64(len(gaps)))
+		}
+
+		// chain positions are only stored with the window progress, once it's broadcast,
+		// otherwise a window failing after this point would have its gaps missed when fetched again
+		l.pending = &pendingWindow{fromKey: fromKey, toKey: toKey, tracker: tracker}
+
+		// if there are no messages, return nil
+		if len(ingestMessages) == 0 {
+			return nil, nil
+		}
+
+		data := &ingest_resolution.LogStoreIngestDataResolution{
+			Messages: ingestMessages,
+		}
+
+		return &data, nil
+	}
+}
+
+// CommitWindow stores the state of the window last fetched, once it was broadcast
+func (l *LogStorePoller) CommitWindow(ctx context.Context, fromKey, toKey int64) error {
+	pending := l.pending
+	l.pending = nil
+	if pending == nil || pending.fromKey != fromKey || pending.toKey != toKey || pending.tracker == nil {
+		return nil
 	}
 
-	// if there are no messages, return nil
-	if len(messages) == 0 {
-		return nil, nil
+	return pending.tracker.Commit(ctx)
+}
+
+// fetchWindow gets the messages to ingest between the cursors (both inclusive).
+// Messages are observed by the tracker, if any, once they passed the publisher filter and the signature check,
+// so forged messages or messages of other publishers don't move the chains forward.
+func (l *LogStorePoller) fetchWindow(ctx context.Context, from, to logstore_client.Cursor, tracker *chainTracker) ([]ingest_resolution.LogStoreIngestMessage, error) {
+	// messages are streamed, so only their ingest form is held in memory, no matter the window size
+	it, err := l.client.IterateAllPartitionsBetween(ctx, l.streamId, from, to)
+	if err != nil {
+		return nil, err
 	}
+	defer it.Close()
+
+	var ingestMessages []ingest_resolution.LogStoreIngestMessage
+	received := 0
+	filtered := 0
+	skippedEncrypted := 0
+	for it.Next() {
+		received++
+		message := it.Message()
+
+		if l.reconciler != nil {
+			l.reconciler.Observe(&message)
+		}
+
+		if l.publisherFilter != nil && !l.publisherFilter.IsAllowed(message.PublisherId) {
+			filtered++
+			continue
+		}
+
+		// we don't trust the log store node, only the publisher
+		if l.verifySignatures {
+			err = logstore_client.VerifySignature(&message)
+			if err != nil {
+				l.logger.Warn(fmt.Sprintf("dropping message %d/%d/%s/%s: %v", message.Timestamp, message.SequenceNumber, message.PublisherId, message.MsgChainId, err))
+				continue
+			}
+		}
 
-	ingestMessages := make([]ingest_resolution.LogStoreIngestMessage, 0, len(messages))
-	for _, message := range messages {
-		// json encode content
-		strContent := ""
-		if message.Content != nil {
-			content, err := json.Marshal(message.Content)
+		if tracker != nil {
+			err = tracker.Observe(ctx, &message)
 			if err != nil {
 				return nil, err
 			}
-			strContent = string(content)
 		}
 
-		idComponents := []string{
-			strconv.Itoa(int(message.Timestamp)),
-			strconv.Itoa(message.SequenceNumber),
-			strconv.Itoa(message.StreamPartition),
+		// ciphertext must never be ingested as if it was the content
+		if message.IsEncrypted() {
+			switch l.encryptedPolicy {
+			case EncryptedMessagesDecrypt:
+				err = logstore_client.DecryptContent(&message, l.groupKeys)
+				if err != nil {
+					l.logger.Warn(fmt.Sprintf("dropping message %d/%d/%s/%s: %v", message.Timestamp, message.SequenceNumber, message.PublisherId, message.MsgChainId, err))
+					continue
+				}
+			case EncryptedMessagesMark:
+				// ingested as it is, toIngestMessage sets the encrypted marker
+			default:
+				skippedEncrypted++
+				continue
+			}
+		}
+
+		ingestMessage, err := l.toIngestMessage(&message)
+		if err != nil {
+			return nil, err
 		}
-		id := strings.Join(idComponents, "_")
+		ingestMessages = append(ingestMessages, ingestMessage)
+	}
 
-		ingestMessages = append(ingestMessages, ingest_resolution.LogStoreIngestMessage{
-			Id:        id,
-			Content:   strContent,
-			Timestamp: uint(message.Timestamp),
-		})
+	if it.Err() != nil {
+		return nil, it.Err()
 	}
 
-	data := &ingest_resolution.LogStoreIngestDataResolution{
-		Messages: ingestMessages,
+	// the window must be fully drained, otherwise we would broadcast an incomplete resolution
+	metadata := it.Metadata()
+	if received+metadata.OutOfRange < metadata.TotalMessages {
+		return nil, fmt.Errorf("received %d messages from %s to %s, but the log store reported %d", received, from, to, metadata.TotalMessages-metadata.OutOfRange)
+	}
+
+	if skippedEncrypted > 0 {
+		l.logger.Info(fmt.Sprintf("skipped %d encrypted messages from %s to %s", skippedEncrypted, from, to))
+	}
+
+	if filtered > 0 {
+		l.logger.Info(fmt.Sprintf("filtered %d of %d messages from %s to %s by publisher", filtered, received, from, to))
+	}
+
+	return ingestMessages, nil
+}
+
+// toIngestMessage converts a log store message into the message ingested by the resolution
+func (l *LogStorePoller) toIngestMessage(message *logstore_client.JSONStreamMessage) (ingest_resolution.LogStoreIngestMessage, error) {
+	payload, err := message.Payload()
+	if err != nil {
+		return ingest_resolution.LogStoreIngestMessage{}, err
+	}
+
+	// encrypted content is the hex ciphertext, whatever the content type
+	var strContent string
+	var encoding ingest_resolution.ContentEncoding
+	switch {
+	case message.IsEncrypted(), message.ContentType == logstore_client.ContentTypeText:
+		strContent = string(payload)
+		encoding = ingest_resolution.EncodingText
+	case message.ContentType == logstore_client.ContentTypeBinary:
+		strContent = base64.StdEncoding.EncodeToString(payload)
+		encoding = ingest_resolution.EncodingBase64
+	case l.canonicalizeContent && len(payload) > 0:
+		canonical, err := logstore_client.CanonicalizeJSON(payload)
+		if err != nil {
+			return ingest_resolution.LogStoreIngestMessage{}, err
+		}
+		strContent = string(canonical)
+		encoding = ingest_resolution.EncodingJSON
+	default:
+		strContent = string(payload)
+		encoding = ingest_resolution.EncodingJSON
+	}
+
+	return ingest_resolution.LogStoreIngestMessage{
+		Id:        messageId(l.messageIdStrategy, message, strContent),
+		Content:   strContent,
+		Timestamp: uint(message.Timestamp),
+		Encrypted: message.IsEncrypted(),
+		Encoding:  encoding,
+	}, nil
+}
+
+// keysToTimestamps converts the key range into a timestamp range, if keys are not timestamps already
+func (l *LogStorePoller) keysToTimestamps(ctx context.Context, fromKey, toKey int64) (int64, int64, error) {
+	if l.keyTimestamps == nil {
+		return fromKey, toKey, nil
+	}
+
+	from, err := l.keyTimestamps.KeyToTimestamp(ctx, fromKey)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to, err := l.keyTimestamps.KeyToTimestamp(ctx, toKey)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return &data, nil
+	return from, to, nil
 }
 
 var emptyResolution = &ingest_resolution.LogStoreIngestDataResolution{
@@ -0,0 +1,150 @@
+package logstore_listener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/kwilteam/kwil-db/core/crypto"
+	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/usherlabs/kwil-ls-oracle/internal/fake_logstore"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"gotest.tools/assert"
+)
+
+func Test_GetData(t *testing.T) {
+	const streamId = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo"
+
+	server := fake_logstore.NewServer()
+	defer server.Close()
+	server.RequireAuth(true)
+	server.SetPageSize(3)
+	server.AddMessages(
+		fake_logstore.Message{StreamId: streamId, Timestamp: 10, Content: json.RawMessage(`{"n":1}`)},
+		fake_logstore.Message{StreamId: streamId, StreamPartition: 1, Timestamp: 10, Content: json.RawMessage(`{"n":2}`)},
+		fake_logstore.Message{StreamId: streamId, Timestamp: 10, SequenceNumber: 1, Content: json.RawMessage(`{"n":3}`)},
+		fake_logstore.Message{StreamId: streamId, Timestamp: 12, Content: json.RawMessage(`{"n":4}`)},
+		fake_logstore.Message{StreamId: streamId, StreamPartition: 1, Timestamp: 15, Content: json.RawMessage(`{"n":5}`)},
+		fake_logstore.Message{StreamId: streamId, Timestamp: 19, Content: json.RawMessage(`{"n":6}`)},
+		// the end of the window is exclusive
+		fake_logstore.Message{StreamId: streamId, Timestamp: 20, Content: json.RawMessage(`{"n":7}`)},
+	)
+	// transient failures are retried by the client
+	server.InjectFault(fake_logstore.Fault{Path: "/partitions/1/range", Times: 1, StatusCode: http.StatusServiceUnavailable})
+
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex("0000000000000000000000000000000000000000000000000000000000000022")
+	assert.NilError(t, err)
+
+	client := logstore_client.NewLogStoreClient(
+		[]string{server.URL},
+		&auth.EthPersonalSigner{Key: *privateKey},
+		logstore_client.WithPartitionCount(2),
+		logstore_client.WithRetryPolicy(logstore_client.RetryPolicy{MaxAttempts: 2, InitialInterval: time.Millisecond}),
+	)
+
+	poller := NewLogStorePoller(NewLogStorePollerOptions{
+		Client:   client,
+		StreamId: streamId,
+		Logger:   log.NewNoOp().Sugar(),
+	})
+
+	data, err := poller.GetData(context.Background(), 10, 20)
+	assert.NilError(t, err)
+	assert.Assert(t, data != nil)
+
+	var contents []string
+	for _, message := range (*data).Messages {
+		contents = append(contents, message.Content)
+	}
+	// sorted by (timestamp, sequenceNumber, partition)
+	assert.DeepEqual(t, contents, []string{`{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`, `{"n":6}`})
+
+	empty, err := poller.GetData(context.Background(), 21, 30)
+	assert.NilError(t, err)
+	assert.Assert(t, empty == nil)
+}
+
+// memoryEventStore is an in-memory eventstore
+type memoryEventStore struct {
+	values map[string][]byte
+}
+
+func (s *memoryEventStore) Broadcast(context.Context, string, []byte) error {
+	return nil
+}
+
+func (s *memoryEventStore) Set(_ context.Context, key []byte, value []byte) error {
+	s.values[string(key)] = value
+	return nil
+}
+
+func (s *memoryEventStore) Get(_ context.Context, key []byte) ([]byte, error) {
+	return s.values[string(key)], nil
+}
+
+func (s *memoryEventStore) Delete(_ context.Context, key []byte) error {
+	delete(s.values, string(key))
+	return nil
+}
+
+func Test_ChainStateCommittedWithWindow(t *testing.T) {
+	const streamId = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo"
+	const publisher = "0x00000000000000000000000000000000000000aa"
+	const deniedPublisher = "0x00000000000000000000000000000000000000bb"
+
+	server := fake_logstore.NewServer()
+	defer server.Close()
+	server.AddMessages(
+		fake_logstore.Message{StreamId: streamId, Timestamp: 10, PublisherId: publisher, MsgChainId: "chain", Content: json.RawMessage(`{"n":1}`)},
+		fake_logstore.Message{StreamId: streamId, Timestamp: 12, PublisherId: deniedPublisher, MsgChainId: "chain", Content: json.RawMessage(`{"n":2}`)},
+		// the same message chain id on another partition is another chain
+		fake_logstore.Message{StreamId: streamId, StreamPartition: 1, Timestamp: 15, PublisherId: publisher, MsgChainId: "chain", Content: json.RawMessage(`{"n":3}`)},
+	)
+
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex("0000000000000000000000000000000000000000000000000000000000000022")
+	assert.NilError(t, err)
+	client := logstore_client.NewLogStoreClient([]string{server.URL}, &auth.EthPersonalSigner{Key: *privateKey}, logstore_client.WithPartitionCount(2))
+
+	eventstore := &memoryEventStore{values: make(map[string][]byte)}
+	poller := NewLogStorePoller(NewLogStorePollerOptions{
+		Client:          client,
+		StreamId:        streamId,
+		EventStore:      eventstore,
+		PublisherFilter: NewPublisherFilter(nil, []string{deniedPublisher}),
+		Logger:          log.NewNoOp().Sugar(),
+	})
+
+	_, err = poller.GetData(context.Background(), 10, 20)
+	assert.NilError(t, err)
+	// nothing is stored until the window is broadcast
+	assert.Equal(t, len(eventstore.values), 0)
+
+	// a window that wasn't the last fetched is not committed
+	err = poller.CommitWindow(context.Background(), 0, 10)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.values), 0)
+
+	_, err = poller.GetData(context.Background(), 10, 20)
+	assert.NilError(t, err)
+	err = poller.CommitWindow(context.Background(), 10, 20)
+	assert.NilError(t, err)
+
+	// filtered messages don't move their chains
+	var keys []string
+	for key := range eventstore.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.DeepEqual(t, keys, []string{chainKey(streamId, 0, publisher, "chain"), chainKey(streamId, 1, publisher, "chain")})
+
+	// each partition keeps the position of its own chain
+	for partition, timestamp := range []int64{10, 15} {
+		cursor, err := getStoredCursor(context.Background(), eventstore, []byte(chainKey(streamId, partition, publisher, "chain")))
+		assert.NilError(t, err)
+		assert.Equal(t, cursor.Timestamp, timestamp)
+	}
+}
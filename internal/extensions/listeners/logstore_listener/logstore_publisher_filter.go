@@ -0,0 +1,45 @@
+package logstore_listener
+
+import "strings"
+
+// PublisherFilter decides which publishers we ingest messages from.
+// If the allowlist is not empty, only its publishers are allowed. The denylist is applied on top of it.
+type PublisherFilter struct {
+	allowlist map[string]struct{}
+	denylist  map[string]struct{}
+}
+
+func NewPublisherFilter(allowlist, denylist []string) *PublisherFilter {
+	return &PublisherFilter{
+		allowlist: publisherSet(allowlist),
+		denylist:  publisherSet(denylist),
+	}
+}
+
+// IsAllowed tells if messages from the publisher should be ingested
+func (f *PublisherFilter) IsAllowed(publisherId string) bool {
+	publisherId = strings.ToLower(publisherId)
+
+	if _, denied := f.denylist[publisherId]; denied {
+		return false
+	}
+
+	if len(f.allowlist) == 0 {
+		return true
+	}
+
+	_, allowed := f.allowlist[publisherId]
+	return allowed
+}
+
+// publisherSet normalizes addresses, as they may come checksummed or not
+func publisherSet(publishers []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(publishers))
+	for _, publisher := range publishers {
+		publisher = strings.ToLower(strings.TrimSpace(publisher))
+		if publisher != "" {
+			set[publisher] = struct{}{}
+		}
+	}
+	return set
+}
@@ -0,0 +1,60 @@
+package logstore_listener
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func Test_PublisherFilter(t *testing.T) {
+	const (
+		publisherA = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d"
+		// publisherA, checksummed
+		checksummedA = "0xD37dC4D7e2c1BDF3eDD89DB0E505394ea69aF43D"
+		publisherB   = "0x00000000000000000000000000000000000000bb"
+		publisherC   = "0x00000000000000000000000000000000000000cc"
+	)
+
+	tests := []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		allowed   map[string]bool
+	}{
+		{
+			name:    "No lists allow everyone",
+			allowed: map[string]bool{publisherA: true, publisherB: true},
+		},
+		{
+			name:      "Allowlist",
+			allowlist: []string{publisherA},
+			allowed:   map[string]bool{publisherA: true, checksummedA: true, publisherB: false},
+		},
+		{
+			name:     "Denylist",
+			denylist: []string{publisherA},
+			allowed:  map[string]bool{publisherA: false, checksummedA: false, publisherB: true},
+		},
+		{
+			name:      "Denylist on top of the allowlist",
+			allowlist: []string{publisherA, publisherB},
+			denylist:  []string{publisherB},
+			allowed:   map[string]bool{publisherA: true, publisherB: false, publisherC: false},
+		},
+		{
+			name:      "Mixed-case and padded addresses in the lists",
+			allowlist: []string{" " + checksummedA, ""},
+			denylist:  []string{"0x00000000000000000000000000000000000000BB "},
+			allowed:   map[string]bool{publisherA: true, checksummedA: true, publisherB: false, "0x00000000000000000000000000000000000000Bb": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := NewPublisherFilter(tt.allowlist, tt.denylist)
+			for publisher, want := range tt.allowed {
+				assert.Equal(t, filter.IsAllowed(publisher), want, publisher)
+			}
+		})
+	}
+}
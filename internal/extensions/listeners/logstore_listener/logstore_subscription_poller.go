@@ -0,0 +1,286 @@
+package logstore_listener
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/cenkalti/backoff"
+	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"github.com/usherlabs/kwil-ls-oracle/internal/paginated_poll_listener"
+	"sync"
+	"time"
+)
+
+// ErrWindowNotStored is returned when the log store node didn't store yet messages we received live for a window
+var ErrWindowNotStored = errors.New("window not fully stored yet")
+
+// maxLiveMessages bounds the messages buffered while windows are not processed, such as while catching up
+const maxLiveMessages = 100_000
+
+// liveMessagesDroppedMetric counts the live messages dropped because the buffer was full
+var liveMessagesDroppedMetric = expvar.NewInt("logstore_listener_live_messages_dropped")
+
+// DefaultReconcileAttempts is how many times a window is held back before being broadcast with what the range query returned
+const DefaultReconcileAttempts = 3
+
+// LogStoreSubscriptionPoller is a poller service that also subscribes to the stream, buffering the messages
+// it receives live until their window closes.
+// Windows are still built from range queries, after the same overhead delay, so every validator broadcasts the same data.
+// The live messages are only a hint: a window missing messages received live is held back, as the log store node
+// is likely still storing them. What each node receives live differs, so they never make windows close earlier.
+// it should implement the [paginated_poll_listener.PollerService] interface.
+type LogStoreSubscriptionPoller struct {
+	*LogStorePoller
+	reconcileAttempts int
+	maxLiveMessages   int
+
+	mu sync.Mutex
+	// runCtx is the context of Run, which subscriptions to partitions added later run in too
+	runCtx context.Context
+	// subscribed is the number of partitions subscribed to
+	subscribed    int
+	subscriptions sync.WaitGroup
+	// live are the messages received by the subscriptions, and not reconciled yet
+	live map[liveRef]struct{}
+	// dropped bounds the timestamps of the live messages dropped while the buffer was full.
+	// The windows they are in can't be checked
+	dropped *timestampRange
+	// observed are the messages received by the range query of the window being reconciled
+	observed map[liveRef]struct{}
+	// failedReconciles counts the times each window was held back, by its start
+	failedReconciles map[logstore_client.Cursor]int
+}
+
+var _ paginated_poll_listener.PollerService[*ingest_resolution.LogStoreIngestDataResolution] = (*LogStoreSubscriptionPoller)(nil)
+
+// timestampRange is a range of timestamps, both inclusive
+type timestampRange struct {
+	from, to int64
+}
+
+// liveRef identifies a message inside a stream
+type liveRef struct {
+	partition int
+	ref       logstore_client.MessageRef
+}
+
+func newLiveRef(message *logstore_client.JSONStreamMessage) liveRef {
+	return liveRef{partition: message.StreamPartition, ref: message.Ref()}
+}
+
+type NewLogStoreSubscriptionPollerOptions struct {
+	NewLogStorePollerOptions
+	// ReconcileAttempts defaults to [DefaultReconcileAttempts]
+	ReconcileAttempts int
+}
+
+func NewLogStoreSubscriptionPoller(options NewLogStoreSubscriptionPollerOptions) *LogStoreSubscriptionPoller {
+	reconcileAttempts := options.ReconcileAttempts
+	if reconcileAttempts < 1 {
+		reconcileAttempts = DefaultReconcileAttempts
+	}
+
+	s := &LogStoreSubscriptionPoller{
+		LogStorePoller:    NewLogStorePoller(options.NewLogStorePollerOptions),
+		reconcileAttempts: reconcileAttempts,
+		maxLiveMessages:   maxLiveMessages,
+		live:              make(map[liveRef]struct{}),
+		observed:          make(map[liveRef]struct{}),
+		failedReconciles:  make(map[logstore_client.Cursor]int),
+	}
+	s.LogStorePoller.reconciler = s
+	return s
+}
+
+// Run subscribes to every partition of the stream, until the context is done. Partitions added later are subscribed to
+// when the next window is fetched.
+// Subscriptions that drop, or that can't be opened because the partition count is unknown, are tried again with a backoff.
+// Messages published meanwhile are only missing from the live buffer, so windows are checked less strictly, never built with less data.
+func (s *LogStoreSubscriptionPoller) Run(ctx context.Context) error {
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.mu.Unlock()
+
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 0
+
+	for ctx.Err() == nil {
+		err := s.subscribeNewPartitions(ctx, ctx)
+		if err == nil {
+			break
+		}
+
+		wait := expBackoff.NextBackOff()
+		s.logger.Warn(fmt.Sprintf("failed to subscribe to stream %s, trying again in %s: %v", s.streamId, wait, err))
+		sleep(ctx, wait)
+	}
+
+	<-ctx.Done()
+	s.subscriptions.Wait()
+	return ctx.Err()
+}
+
+// GetData subscribes to the partitions added to the stream since the last window, then gets the window
+// like [LogStorePoller.GetData]
+func (s *LogStoreSubscriptionPoller) GetData(ctx context.Context, fromKey, toKey int64) (**ingest_resolution.LogStoreIngestDataResolution, error) {
+	s.mu.Lock()
+	runCtx := s.runCtx
+	s.mu.Unlock()
+
+	if runCtx != nil && runCtx.Err() == nil {
+		err := s.subscribeNewPartitions(ctx, runCtx)
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("failed to check the partitions of stream %s: %v", s.streamId, err))
+		}
+	}
+
+	return s.LogStorePoller.GetData(ctx, fromKey, toKey)
+}
+
+// subscribeNewPartitions subscribes to the partitions of the stream that are not subscribed to yet.
+// The subscriptions run until runCtx is done
+func (s *LogStoreSubscriptionPoller) subscribeNewPartitions(ctx, runCtx context.Context) error {
+	partitionCount, err := s.client.GetStreamPartitionCount(ctx, s.streamId)
+	if err != nil {
+		return fmt.Errorf("failed to get partition count: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ; s.subscribed < partitionCount; s.subscribed++ {
+		partition := s.subscribed
+		s.subscriptions.Add(1)
+		go func() {
+			defer s.subscriptions.Done()
+			s.subscribe(runCtx, partition)
+		}()
+	}
+	return nil
+}
+
+func (s *LogStoreSubscriptionPoller) subscribe(ctx context.Context, partition int) {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.MaxElapsedTime = 0
+
+	for ctx.Err() == nil {
+		subscription, err := s.client.Subscribe(ctx, s.streamId, partition)
+		if err != nil {
+			wait := expBackoff.NextBackOff()
+			s.logger.Warn(fmt.Sprintf("failed to subscribe to partition %d, trying again in %s: %v", partition, wait, err))
+			sleep(ctx, wait)
+			continue
+		}
+		expBackoff.Reset()
+		s.logger.Info(fmt.Sprintf("subscribed to partition %d on %s", partition, subscription.Endpoint))
+
+		// unblocks Next when we are done
+		stop := context.AfterFunc(ctx, func() {
+			_ = subscription.Close()
+		})
+
+		for {
+			message, err := subscription.Next()
+			if err != nil {
+				if ctx.Err() == nil {
+					s.logger.Warn(fmt.Sprintf("subscription to partition %d dropped: %v", partition, err))
+				}
+				break
+			}
+			s.record(&message)
+		}
+
+		stop()
+		_ = subscription.Close()
+	}
+}
+
+// record buffers a message received live
+func (s *LogStoreSubscriptionPoller) record(message *logstore_client.JSONStreamMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.live) >= s.maxLiveMessages {
+		if s.dropped == nil {
+			s.logger.Warn(fmt.Sprintf("live buffer is full with %d messages, the windows of the messages dropped won't be checked", s.maxLiveMessages))
+			s.dropped = &timestampRange{from: message.Timestamp, to: message.Timestamp}
+		}
+		s.dropped.from = min(s.dropped.from, message.Timestamp)
+		s.dropped.to = max(s.dropped.to, message.Timestamp)
+		liveMessagesDroppedMetric.Add(1)
+		return
+	}
+	s.live[newLiveRef(message)] = struct{}{}
+}
+
+// Observe sees the messages of the range query of a window
+func (s *LogStoreSubscriptionPoller) Observe(message *logstore_client.JSONStreamMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observed[newLiveRef(message)] = struct{}{}
+}
+
+// Reconcile holds the window back if the range query missed messages received live, as the log store node
+// didn't store them yet. After the reconcile attempts, the range query is trusted, as it's what every validator sees.
+func (s *LogStoreSubscriptionPoller) Reconcile(from, to logstore_client.Cursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	observed := s.observed
+	s.observed = make(map[liveRef]struct{})
+
+	// the live messages of the window are incomplete, so they can't tell if the range query is
+	if s.dropped != nil && s.dropped.from <= to.Timestamp && s.dropped.to >= from.Timestamp {
+		s.logger.Warn(fmt.Sprintf("live messages from %d to %d were dropped, the window from %s to %s is not checked", s.dropped.from, s.dropped.to, from, to))
+		s.forgetLive(to)
+		return nil
+	}
+
+	missing := 0
+	for ref := range s.live {
+		cursor := logstore_client.Cursor{Timestamp: ref.ref.Timestamp, SequenceNumber: ref.ref.SequenceNumber}
+		if cursor.Compare(from) < 0 || cursor.Compare(to) > 0 {
+			continue
+		}
+		if _, ok := observed[ref]; !ok {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		s.failedReconciles[from]++
+		if s.failedReconciles[from] <= s.reconcileAttempts {
+			return fmt.Errorf("%w: %d messages received live from %s to %s are missing", ErrWindowNotStored, missing, from, to)
+		}
+		s.logger.Warn(fmt.Sprintf("%d messages received live from %s to %s were never stored, ingesting the window without them", missing, from, to))
+	}
+	delete(s.failedReconciles, from)
+
+	s.forgetLive(to)
+	return nil
+}
+
+// forgetLive drops the live messages up to the end of a window that is done, as the windows before it are done too
+func (s *LogStoreSubscriptionPoller) forgetLive(to logstore_client.Cursor) {
+	for ref := range s.live {
+		cursor := logstore_client.Cursor{Timestamp: ref.ref.Timestamp, SequenceNumber: ref.ref.SequenceNumber}
+		if cursor.Compare(to) <= 0 {
+			delete(s.live, ref)
+		}
+	}
+
+	if s.dropped != nil && s.dropped.to <= to.Timestamp {
+		s.dropped = nil
+	}
+}
+
+// sleep waits for the duration, or until the context is done
+func sleep(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
+	}
+}
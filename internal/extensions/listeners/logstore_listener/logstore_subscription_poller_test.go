@@ -0,0 +1,134 @@
+package logstore_listener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kwilteam/kwil-db/core/crypto"
+	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/usherlabs/kwil-ls-oracle/internal/fake_logstore"
+	"github.com/usherlabs/kwil-ls-oracle/internal/logstore_client"
+	"gotest.tools/assert"
+)
+
+// waitFor polls the condition until it's true, failing the test after a few seconds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_SubscriptionPoller(t *testing.T) {
+	const streamId = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo"
+	const publisher = "0x00000000000000000000000000000000000000aa"
+
+	server := fake_logstore.NewServer()
+	defer server.Close()
+
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex("0000000000000000000000000000000000000000000000000000000000000022")
+	assert.NilError(t, err)
+	client := logstore_client.NewLogStoreClient(
+		[]string{server.URL},
+		&auth.EthPersonalSigner{Key: *privateKey},
+		logstore_client.WithSubscriptionEndpoint(server.URL, ""),
+	)
+
+	poller := NewLogStoreSubscriptionPoller(NewLogStoreSubscriptionPollerOptions{
+		NewLogStorePollerOptions: NewLogStorePollerOptions{
+			Client:   client,
+			StreamId: streamId,
+			Logger:   log.NewNoOp().Sugar(),
+		},
+		ReconcileAttempts: 1,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = poller.Run(ctx)
+	}()
+	waitFor(t, func() bool { return server.Subscribers(streamId, 0) == 1 })
+
+	message := func(timestamp int64) fake_logstore.Message {
+		return fake_logstore.Message{StreamId: streamId, Timestamp: timestamp, PublisherId: publisher, MsgChainId: "chain", Content: json.RawMessage(`{}`)}
+	}
+	// publishes a message live, waiting for the poller to buffer it
+	publish := func(message fake_logstore.Message) {
+		poller.mu.Lock()
+		buffered := len(poller.live)
+		poller.mu.Unlock()
+		server.Publish(message)
+		waitFor(t, func() bool {
+			poller.mu.Lock()
+			defer poller.mu.Unlock()
+			return len(poller.live) > buffered || poller.dropped != nil
+		})
+	}
+
+	t.Run("Missing live messages hold the window back", func(t *testing.T) {
+		server.AddMessages(message(10))
+		publish(message(10))
+		publish(message(15))
+
+		_, err := poller.GetData(ctx, 10, 20)
+		assert.Assert(t, errors.Is(err, ErrWindowNotStored), "%v", err)
+
+		// after the reconcile attempts, the range query is what every validator sees
+		data, err := poller.GetData(ctx, 10, 20)
+		assert.NilError(t, err)
+		assert.Equal(t, len((*data).Messages), 1)
+	})
+
+	t.Run("Late messages are ingested once stored", func(t *testing.T) {
+		publish(message(25))
+
+		data, err := poller.GetData(ctx, 20, 30)
+		assert.Assert(t, errors.Is(err, ErrWindowNotStored), "%v", err)
+		assert.Assert(t, data == nil)
+
+		server.AddMessages(message(25))
+		data, err = poller.GetData(ctx, 20, 30)
+		assert.NilError(t, err)
+		assert.Equal(t, len((*data).Messages), 1)
+	})
+
+	t.Run("Windows with dropped live messages are not checked", func(t *testing.T) {
+		dropped := liveMessagesDroppedMetric.Value()
+		poller.mu.Lock()
+		poller.maxLiveMessages = 1
+		poller.mu.Unlock()
+
+		publish(message(35))
+		publish(message(36))
+		assert.Equal(t, liveMessagesDroppedMetric.Value(), dropped+1)
+
+		// the live message that was kept is missing, but the window can't be checked anyway
+		data, err := poller.GetData(ctx, 30, 40)
+		assert.NilError(t, err)
+		assert.Assert(t, data == nil)
+
+		poller.mu.Lock()
+		defer poller.mu.Unlock()
+		assert.Assert(t, poller.dropped == nil)
+		assert.Equal(t, len(poller.live), 0)
+		poller.maxLiveMessages = maxLiveMessages
+	})
+
+	t.Run("Dropped subscriptions reconnect", func(t *testing.T) {
+		server.DropSubscriptions()
+		waitFor(t, func() bool { return server.Subscribers(streamId, 0) == 1 })
+
+		publish(message(45))
+		_, err := poller.GetData(ctx, 40, 50)
+		assert.Assert(t, errors.Is(err, ErrWindowNotStored), "%v", err)
+	})
+}
@@ -2,6 +2,7 @@ package ingest_resolution
 
 import (
 	"context"
+	"fmt"
 	"github.com/kwilteam/kwil-db/common"
 	"github.com/kwilteam/kwil-db/core/types"
 	"github.com/kwilteam/kwil-db/extensions/resolutions"
@@ -55,7 +56,7 @@ func (r *IngestResolution[T]) GetResolutionConfig() resolutions.ResolutionConfig
 			}
 			// Ingest the data
 			// This is where you would ingest the data using actions inside the app, if the action has the name of the resolution
-			contracts, err := getDataSetsWithAction(ctx, app, r.ResolutionName)
+			contracts, parameterCounts, err := getDataSetsWithAction(ctx, app, r.ResolutionName)
 
 			if err != nil {
 				return err
@@ -86,10 +87,17 @@ func (r *IngestResolution[T]) GetResolutionConfig() resolutions.ResolutionConfig
 
 			for _, contract := range selectedContracts {
 				for _, anyArgs := range anyArgsSets {
-					_, err := app.Engine.Procedure(ctx, app.DB, &common.ExecutionData{
+					// arguments added over time are only passed to actions declaring them,
+					// so actions deployed with fewer parameters keep working
+					args, err := argsForParameters(anyArgs, parameterCounts[contract.DBID])
+					if err != nil {
+						return fmt.Errorf("action %s of dataset %s: %w", r.ResolutionName, contract.DBID, err)
+					}
+
+					_, err = app.Engine.Procedure(ctx, app.DB, &common.ExecutionData{
 						Dataset:   contract.DBID,
 						Procedure: r.ResolutionName,
-						Args:      anyArgs,
+						Args:      args,
 						Signer:    resolution.Proposer,
 						Caller:    string(resolution.Proposer),
 					})
@@ -104,17 +112,19 @@ func (r *IngestResolution[T]) GetResolutionConfig() resolutions.ResolutionConfig
 	}
 }
 
-func getDataSetsWithAction(ctx context.Context, app *common.App, action string) ([]types.DatasetIdentifier, error) {
+// getDataSetsWithAction finds the datasets with the action, and how many parameters it declares in each of them
+func getDataSetsWithAction(ctx context.Context, app *common.App, action string) ([]types.DatasetIdentifier, map[string]int, error) {
 	allContracts, err := app.Engine.ListDatasets(ctx, []byte{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var contracts []types.DatasetIdentifier
+	parameterCounts := make(map[string]int)
 	for _, contract := range allContracts {
 		schema, err := app.Engine.GetSchema(ctx, contract.DBID)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		allContractProcedures := schema.Procedures
@@ -122,9 +132,19 @@ func getDataSetsWithAction(ctx context.Context, app *common.App, action string)
 		for _, contractProcedure := range allContractProcedures {
 			if contractProcedure.Name == action {
 				contracts = append(contracts, *contract)
+				parameterCounts[contract.DBID] = len(contractProcedure.Args)
 				break
 			}
 		}
 	}
-	return contracts, nil
+	return contracts, parameterCounts, nil
+}
+
+// argsForParameters keeps the leading arguments an action declares parameters for.
+// Arguments are only ever appended to the resolution, so older actions get the same arguments they always did.
+func argsForParameters(args []any, parameterCount int) ([]any, error) {
+	if parameterCount > len(args) {
+		return nil, fmt.Errorf("declares %d parameters, but the resolution only provides %d arguments", parameterCount, len(args))
+	}
+	return args[:parameterCount], nil
 }
@@ -0,0 +1,75 @@
+package ingest_resolution
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/kwilteam/kwil-db/common"
+	"github.com/kwilteam/kwil-db/common/sql"
+	"github.com/kwilteam/kwil-db/core/types"
+	"github.com/kwilteam/kwil-db/extensions/resolutions"
+	"gotest.tools/assert"
+)
+
+// fakeEngine has datasets with a log_store_ingest action, by dbid, declaring the given parameters.
+// Like the real engine, it rejects calls that don't match the declared parameter count.
+type fakeEngine struct {
+	common.Engine
+	parameters map[string][]string
+	calls      map[string][][]any
+}
+
+func (e *fakeEngine) ListDatasets(context.Context, []byte) ([]*types.DatasetIdentifier, error) {
+	var datasets []*types.DatasetIdentifier
+	for dbid := range e.parameters {
+		datasets = append(datasets, &types.DatasetIdentifier{Name: dbid, Owner: []byte("owner"), DBID: dbid})
+	}
+	return datasets, nil
+}
+
+func (e *fakeEngine) GetSchema(_ context.Context, dbid string) (*common.Schema, error) {
+	return &common.Schema{Procedures: []*common.Procedure{{Name: "log_store_ingest", Args: e.parameters[dbid]}}}, nil
+}
+
+func (e *fakeEngine) Procedure(_ context.Context, _ sql.DB, options *common.ExecutionData) (*sql.ResultSet, error) {
+	if len(options.Args) != len(e.parameters[options.Dataset]) {
+		return nil, fmt.Errorf("incorrect number of arguments: procedure requires %d arguments, but %d were provided", len(e.parameters[options.Dataset]), len(options.Args))
+	}
+	e.calls[options.Dataset] = append(e.calls[options.Dataset], options.Args)
+	return nil, nil
+}
+
+func TestResolveFuncMatchesDeclaredParameters(t *testing.T) {
+	engine := &fakeEngine{
+		parameters: map[string][]string{
+			// the original signature, before encrypted and encoding were added
+			"v1": {"$id", "$content", "$timestamp"},
+			"v2": {"$id", "$content", "$timestamp", "$encrypted"},
+			"v3": {"$id", "$content", "$timestamp", "$encrypted", "$encoding"},
+		},
+		calls: make(map[string][][]any),
+	}
+
+	resolution := *LogStoreIngestResolution
+	resolution.ContractSelectors = []ContractSelector{{Owner: "*", Name: "*"}}
+
+	data := &LogStoreIngestDataResolution{Messages: []LogStoreIngestMessage{
+		{Id: "1", Content: `{"a":1}`, Timestamp: 10},
+		{Id: "2", Content: "aGk=", Timestamp: 11, Encrypted: true, Encoding: EncodingBase64},
+	}}
+	body, err := data.MarshalBinary()
+	assert.NilError(t, err)
+
+	err = resolution.GetResolutionConfig().ResolveFunc(context.Background(), &common.App{Engine: engine}, &resolutions.Resolution{Body: body})
+	assert.NilError(t, err)
+
+	assert.DeepEqual(t, engine.calls["v1"], [][]any{{"1", `{"a":1}`, "10"}, {"2", "aGk=", "11"}})
+	assert.DeepEqual(t, engine.calls["v2"], [][]any{{"1", `{"a":1}`, "10", "false"}, {"2", "aGk=", "11", "true"}})
+	assert.DeepEqual(t, engine.calls["v3"], [][]any{{"1", `{"a":1}`, "10", "false", "json"}, {"2", "aGk=", "11", "true", "base64"}})
+
+	// actions declaring more parameters than we provide can't be called
+	engine.parameters = map[string][]string{"v4": {"$id", "$content", "$timestamp", "$encrypted", "$encoding", "$other"}}
+	err = resolution.GetResolutionConfig().ResolveFunc(context.Background(), &common.App{Engine: engine}, &resolutions.Resolution{Body: body})
+	assert.ErrorContains(t, err, "declares 6 parameters")
+}
@@ -11,8 +11,25 @@ type LogStoreIngestMessage struct {
 	Id        string
 	Content   string
 	Timestamp uint
+	// Encrypted marks that Content is the ciphertext, as published, and not the message content itself.
+	// It's optional so resolutions encoded before it existed still decode.
+	Encrypted bool `rlp:"optional"`
+	// Encoding says how Content is encoded, so actions can decode it. Empty means EncodingJSON.
+	Encoding ContentEncoding `rlp:"optional"`
 }
 
+// ContentEncoding is how a message payload is encoded into the Content string
+type ContentEncoding string
+
+const (
+	// EncodingJSON is JSON content, kept as the publisher serialized it
+	EncodingJSON ContentEncoding = "json"
+	// EncodingBase64 is binary content, encoded as standard base64
+	EncodingBase64 ContentEncoding = "base64"
+	// EncodingText is plain text content, as it is
+	EncodingText ContentEncoding = "text"
+)
+
 func (m *LogStoreIngestMessage) MarshalBinary() ([]byte, error) {
 	return serialize.Encode(m)
 }
@@ -92,6 +109,9 @@ func (r *LogStoreIngestDataResolution) split(numberOfChunks int) []*LogStoreInge
 	return chunks
 }
 
+// GetArgs returns the arguments of each message, in the order of the `log_store_ingest` parameters:
+// ($id, $content, $timestamp, $encrypted, $encoding). Actions may declare only the leading ones,
+// such as ($id, $content, $timestamp), and only those are passed to them.
 func (r *LogStoreIngestDataResolution) GetArgs() [][]*string {
 	var argsSet [][]*string
 	for _, message := range r.Messages {
@@ -100,6 +120,13 @@ func (r *LogStoreIngestDataResolution) GetArgs() [][]*string {
 		args = append(args, &message.Content)
 		tsString := strconv.Itoa(int(message.Timestamp))
 		args = append(args, &tsString)
+		encryptedString := strconv.FormatBool(message.Encrypted)
+		args = append(args, &encryptedString)
+		encodingString := string(message.Encoding)
+		if encodingString == "" {
+			encodingString = string(EncodingJSON)
+		}
+		args = append(args, &encodingString)
 		argsSet = append(argsSet, args)
 	}
 
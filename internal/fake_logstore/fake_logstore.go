@@ -0,0 +1,492 @@
+// Package fake_logstore is an in-memory Log Store node, served over HTTP for tests.
+// It serves the messages it's seeded with on the same endpoints as a real node,
+// and can inject faults such as latency, server errors, truncated pages and auth failures.
+// It also serves the subscriptions of the Streamr node websocket plugin, with payloadMetadata enabled,
+// to send messages live.
+package fake_logstore
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a stream message, with the same fields a node serves
+type Message struct {
+	StreamId        string          `json:"streamId"`
+	StreamPartition int             `json:"streamPartition"`
+	Timestamp       int64           `json:"timestamp"`
+	SequenceNumber  int             `json:"sequenceNumber"`
+	PublisherId     string          `json:"publisherId"`
+	MsgChainId      string          `json:"msgChainId"`
+	PrevMsgRef      *MessageRef     `json:"prevMsgRef"`
+	MessageType     int             `json:"messageType"`
+	ContentType     int             `json:"contentType"`
+	EncryptionType  int             `json:"encryptionType"`
+	GroupKeyId      string          `json:"groupKeyId,omitempty"`
+	Content         json.RawMessage `json:"content"`
+	NewGroupKey     json.RawMessage `json:"newGroupKey,omitempty"`
+	SignatureType   int             `json:"signatureType"`
+	Signature       string          `json:"signature"`
+}
+
+type MessageRef struct {
+	Timestamp      int64 `json:"timestamp"`
+	SequenceNumber int   `json:"sequenceNumber"`
+}
+
+// Fault changes how the server answers the requests matching it
+type Fault struct {
+	// Path matches requests whose path contains it. Empty matches every request
+	Path string
+	// Times is how many requests the fault applies to. 0 applies it until the faults are cleared
+	Times int
+	// Latency delays the response
+	Latency time.Duration
+	// StatusCode answers with this status and no data, such as a 5xx or a 429
+	StatusCode int
+	// RetryAfter is sent as the Retry-After header of the status code
+	RetryAfter string
+	// TruncatePage cuts the body of the response in half, as if the connection dropped
+	TruncatePage bool
+}
+
+// Server is a fake Log Store node. Its zero value is not usable, see [NewServer]
+type Server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// messages are sorted by (timestamp, sequenceNumber), by stream and partition
+	messages    map[streamPartition][]Message
+	pageSize    int
+	notReady    map[streamPartition]bool
+	requireAuth bool
+	rejectAuth  bool
+	faults      []*Fault
+	requests    []url.URL
+	// subscribers are the open websocket subscriptions, by stream and partition
+	subscribers map[streamPartition][]*websocket.Conn
+	apiKey      string
+}
+
+type streamPartition struct {
+	streamId  string
+	partition int
+}
+
+// NewServer starts a fake node with no messages. It must be closed by the caller
+func NewServer() *Server {
+	s := &Server{
+		messages:    make(map[streamPartition][]Message),
+		notReady:    make(map[streamPartition]bool),
+		subscribers: make(map[streamPartition][]*websocket.Conn),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// AddMessages stores messages, on the stream and partition they are from
+func (s *Server) AddMessages(messages ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range messages {
+		key := streamPartition{message.StreamId, message.StreamPartition}
+		s.messages[key] = append(s.messages[key], message)
+	}
+	for key := range s.messages {
+		slices.SortStableFunc(s.messages[key], compareMessages)
+	}
+}
+
+// SetPageSize splits range responses in pages of this many messages. 0 disables pagination.
+// As on a real node, a page can't make progress if more messages than its size share a timestamp.
+func (s *Server) SetPageSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = size
+}
+
+// SetReady sets what the ready endpoint answers for a partition. Partitions are ready by default
+func (s *Server) SetReady(streamId string, partition int, ready bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notReady[streamPartition{streamId, partition}] = !ready
+}
+
+// RequireAuth rejects the requests to authenticated endpoints without a well-formed authorization header
+func (s *Server) RequireAuth(required bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requireAuth = required
+}
+
+// RejectAuth rejects every authorization header, as a node would for an unknown or expired signature
+func (s *Server) RejectAuth(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejectAuth = reject
+}
+
+// InjectFault adds a fault. Faults apply in the order they were added, the first one matching a request wins
+func (s *Server) InjectFault(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes every fault
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// Requests returns the URLs requested so far
+func (s *Server) Requests() []url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, *r.URL)
+	fault := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(fault.Latency):
+			}
+		}
+		if fault.StatusCode != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			http.Error(w, http.StatusText(fault.StatusCode), fault.StatusCode)
+			return
+		}
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/streams/") && strings.HasSuffix(r.URL.Path, "/subscribe") {
+		s.subscribe(w, r)
+		return
+	}
+
+	status, response := s.route(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fault != nil && fault.TruncatePage {
+		body = body[:len(body)/2]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
+
+// RequireApiKey makes subscriptions require the API key. Empty accepts any
+func (s *Server) RequireApiKey(apiKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = apiKey
+}
+
+// Publish sends messages live to the subscriptions of their stream and partition, without storing them.
+// Storing them later with AddMessages makes them arrive late to the log store node.
+func (s *Server) Publish(messages ...Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range messages {
+		frame, err := json.Marshal(map[string]any{
+			"content": message.Content,
+			"metadata": map[string]any{
+				"timestamp":      message.Timestamp,
+				"sequenceNumber": message.SequenceNumber,
+				"publisherId":    message.PublisherId,
+				"msgChainId":     message.MsgChainId,
+			},
+		})
+		if err != nil {
+			panic(err)
+		}
+		for _, conn := range s.subscribers[streamPartition{message.StreamId, message.StreamPartition}] {
+			_ = conn.WriteMessage(websocket.TextMessage, frame)
+		}
+	}
+}
+
+// Subscribers is the number of open subscriptions to a partition
+func (s *Server) Subscribers(streamId string, partition int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers[streamPartition{streamId, partition}])
+}
+
+// DropSubscriptions closes every open subscription, as if the node restarted
+func (s *Server) DropSubscriptions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, conns := range s.subscribers {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		delete(s.subscribers, key)
+	}
+}
+
+// subscribe serves /streams/:id/subscribe?partitions=:partition&apiKey=:apiKey, for a single partition
+func (s *Server) subscribe(w http.ResponseWriter, r *http.Request) {
+	streamId, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/streams/"), "/subscribe"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partition, err := strconv.Atoi(r.URL.Query().Get("partitions"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	apiKey := s.apiKey
+	s.mu.Unlock()
+	if apiKey != "" && r.URL.Query().Get("apiKey") != apiKey {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	key := streamPartition{streamId, partition}
+	s.mu.Lock()
+	s.subscribers[key] = append(s.subscribers[key], conn)
+	s.mu.Unlock()
+
+	// the subscription is open until either side closes it
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	s.subscribers[key] = slices.DeleteFunc(s.subscribers[key], func(c *websocket.Conn) bool { return c == conn })
+	s.mu.Unlock()
+	_ = conn.Close()
+}
+
+// takeFault finds the fault for a request, consuming one of its times
+func (s *Server) takeFault(path string) *Fault {
+	for i, fault := range s.faults {
+		if !strings.Contains(path, fault.Path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = slices.Delete(s.faults, i, i+1)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// route answers a request, returning the status and the response to encode
+func (s *Server) route(r *http.Request) (int, any) {
+	// /stores/:id/data/partitions/:partition/(last|range) and /stores/:id/partitions/:partition/ready
+	// the stream id is path escaped, so its slashes don't split it
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/stores/"), "/")
+	if len(parts) < 4 || !strings.HasPrefix(r.URL.EscapedPath(), "/stores/") {
+		return http.StatusNotFound, nil
+	}
+	streamId, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	rest := parts[1:]
+	if rest[0] == "data" {
+		rest = rest[1:]
+	}
+	if len(rest) != 3 || rest[0] != "partitions" {
+		return http.StatusNotFound, nil
+	}
+	partition, err := strconv.Atoi(rest[1])
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	key := streamPartition{streamId, partition}
+
+	if rest[2] == "ready" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return http.StatusOK, map[string]bool{"ready": !s.notReady[key]}
+	}
+
+	if status := s.checkAuth(r); status != http.StatusOK {
+		return status, nil
+	}
+
+	query := r.URL.Query()
+	switch rest[2] {
+	case "last":
+		count, err := strconv.Atoi(query.Get("count"))
+		if err != nil {
+			return http.StatusBadRequest, nil
+		}
+		return http.StatusOK, s.last(key, count)
+	case "range":
+		return s.queryRange(key, query)
+	default:
+		return http.StatusNotFound, nil
+	}
+}
+
+// checkAuth validates the shape of the authorization header, "basic base64(address:signature)".
+// Signatures are not verified, RejectAuth simulates a node refusing them.
+func (s *Server) checkAuth(r *http.Request) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rejectAuth {
+		return http.StatusUnauthorized
+	}
+	if !s.requireAuth {
+		return http.StatusOK
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return http.StatusUnauthorized
+	}
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return http.StatusUnauthorized
+	}
+	address, signature, ok := strings.Cut(string(decoded), ":")
+	if !ok || address == "" || signature == "" {
+		return http.StatusUnauthorized
+	}
+	return http.StatusOK
+}
+
+type messagesResponse struct {
+	Messages []Message        `json:"messages"`
+	Metadata messagesMetadata `json:"metadata"`
+}
+
+type messagesMetadata struct {
+	HasNext       bool `json:"hasNext"`
+	TotalMessages int  `json:"totalMessages"`
+}
+
+// last returns the last count messages, or the first one when count is -1
+func (s *Server) last(key streamPartition, count int) messagesResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := s.messages[key]
+	var selected []Message
+	switch {
+	case count == -1:
+		selected = messages[:min(1, len(messages))]
+	case count > 0:
+		selected = messages[max(0, len(messages)-count):]
+	}
+
+	return messagesResponse{
+		Messages: append([]Message{}, selected...),
+		Metadata: messagesMetadata{TotalMessages: len(selected)},
+	}
+}
+
+// queryRange returns a page of the messages in the range, starting at its beginning.
+// The next page is requested from the timestamp of the last message, so pages overlap at their boundaries as on a real node.
+func (s *Server) queryRange(key streamPartition, query url.Values) (int, any) {
+	from, err := strconv.ParseInt(query.Get("fromTimestamp"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	to, err := strconv.ParseInt(query.Get("toTimestamp"), 10, 64)
+	if err != nil {
+		return http.StatusBadRequest, nil
+	}
+	fromSequenceNumber, toSequenceNumber := 0, math.MaxInt32
+	if v := query.Get("fromSequenceNumber"); v != "" {
+		if fromSequenceNumber, err = strconv.Atoi(v); err != nil {
+			return http.StatusBadRequest, nil
+		}
+	}
+	if v := query.Get("toSequenceNumber"); v != "" {
+		if toSequenceNumber, err = strconv.Atoi(v); err != nil {
+			return http.StatusBadRequest, nil
+		}
+	}
+	start := MessageRef{Timestamp: from, SequenceNumber: fromSequenceNumber}
+	end := MessageRef{Timestamp: to, SequenceNumber: toSequenceNumber}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var inRange []Message
+	for _, message := range s.messages[key] {
+		ref := MessageRef{Timestamp: message.Timestamp, SequenceNumber: message.SequenceNumber}
+		if compareRefs(ref, start) >= 0 && compareRefs(ref, end) <= 0 {
+			inRange = append(inRange, message)
+		}
+	}
+
+	page := inRange
+	if s.pageSize > 0 && len(page) > s.pageSize {
+		page = page[:s.pageSize]
+	}
+
+	return http.StatusOK, messagesResponse{
+		Messages: append([]Message{}, page...),
+		Metadata: messagesMetadata{
+			HasNext:       len(page) < len(inRange),
+			TotalMessages: len(inRange),
+		},
+	}
+}
+
+func compareMessages(a, b Message) int {
+	return compareRefs(
+		MessageRef{Timestamp: a.Timestamp, SequenceNumber: a.SequenceNumber},
+		MessageRef{Timestamp: b.Timestamp, SequenceNumber: b.SequenceNumber},
+	)
+}
+
+func compareRefs(a, b MessageRef) int {
+	if a.Timestamp != b.Timestamp {
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	}
+	return cmp.Compare(a.SequenceNumber, b.SequenceNumber)
+}
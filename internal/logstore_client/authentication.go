@@ -4,9 +4,15 @@ import (
 	"encoding/base64"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"sync"
+	"time"
 )
 
-func createAuthHeader(signer auth.EthPersonalSigner) (string, error) {
+// DefaultAuthRefreshInterval is how long a signed header is reused before signing a new one.
+// The header only signs the signer address, so a leaked header stays valid as long as the node accepts the address.
+const DefaultAuthRefreshInterval = 10 * time.Minute
+
+func createAuthHeader(signer auth.Signer) (string, error) {
 	user := signer.Identity()
 	userStr := hexutil.Encode(user)
 
@@ -21,3 +27,50 @@ func createAuthHeader(signer auth.EthPersonalSigner) (string, error) {
 
 	return "basic " + base64Token, nil
 }
+
+// authenticator caches the authorization header of a signer, as signing is expensive
+// and we would otherwise do it for every single request.
+type authenticator struct {
+	signer          auth.Signer
+	refreshInterval time.Duration
+
+	mu       sync.Mutex
+	header   string
+	signedAt time.Time
+}
+
+func newAuthenticator(signer auth.Signer, refreshInterval time.Duration) *authenticator {
+	return &authenticator{
+		signer:          signer,
+		refreshInterval: refreshInterval,
+	}
+}
+
+// Header returns the cached header, signing a new one if it's missing or expired
+func (a *authenticator) Header() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.header != "" && now.Sub(a.signedAt) < a.refreshInterval {
+		return a.header, nil
+	}
+
+	header, err := createAuthHeader(a.signer)
+	if err != nil {
+		return "", err
+	}
+
+	a.header = header
+	a.signedAt = now
+	return header, nil
+}
+
+// Invalidate drops the cached header, so the next request signs a new one.
+// It's used when the node rejects our header.
+func (a *authenticator) Invalidate() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.header = ""
+}
@@ -0,0 +1,55 @@
+package logstore_client
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// ChainReader reads block data from the chain the log store network runs on.
+// The client is dialed once and shared by every call, it's safe for concurrent use.
+type ChainReader struct {
+	rpcUrl string
+	client *ethclient.Client
+}
+
+func NewChainReader(rpcUrl string) (*ChainReader, error) {
+	if rpcUrl == "" {
+		return nil, fmt.Errorf("missing chain rpc url")
+	}
+
+	// http endpoints are only reached on the first call, websocket ones are connected here
+	client, err := ethclient.Dial(rpcUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", rpcUrl, err)
+	}
+
+	return &ChainReader{rpcUrl: rpcUrl, client: client}, nil
+}
+
+// GetLatestBlockHeight gets the height of the latest block of the chain
+func (r *ChainReader) GetLatestBlockHeight(ctx context.Context) (int64, error) {
+	height, err := r.client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the latest block from %s: %w", r.rpcUrl, err)
+	}
+
+	return int64(height), nil
+}
+
+// GetBlockTimestamp gets the timestamp of a block, in milliseconds
+func (r *ChainReader) GetBlockTimestamp(ctx context.Context, height int64) (int64, error) {
+	header, err := r.client.HeaderByNumber(ctx, big.NewInt(height))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block %d: %w", height, err)
+	}
+
+	return int64(header.Time) * 1000, nil
+}
+
+// Close closes the connection to the rpc
+func (r *ChainReader) Close() {
+	r.client.Close()
+}
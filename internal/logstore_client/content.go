@@ -0,0 +1,84 @@
+package logstore_client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Streamr content types
+const (
+	ContentTypeJSON   = 0
+	ContentTypeBinary = 1
+	ContentTypeText   = 2
+)
+
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Payload gets the content of a message as the publisher sent it.
+// JSON content is returned verbatim, binary content is decoded from the hex string it's transported as,
+// and text content is returned without the JSON string quotes.
+// Encrypted content is returned as the hex ciphertext, as we can't know its content type before decrypting it.
+func (m *JSONStreamMessage) Payload() ([]byte, error) {
+	if m.IsEncrypted() {
+		content, err := serializedContent(m.Content)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(content), nil
+	}
+
+	switch m.ContentType {
+	case ContentTypeJSON:
+		return m.Content, nil
+	case ContentTypeBinary:
+		var content string
+		err := json.Unmarshal(m.Content, &content)
+		if err != nil {
+			return nil, fmt.Errorf("binary content is not a string: %w", err)
+		}
+		payload, err := hex.DecodeString(content)
+		if err != nil {
+			return nil, fmt.Errorf("binary content is not hex encoded: %w", err)
+		}
+		return payload, nil
+	case ContentTypeText:
+		var content string
+		err := json.Unmarshal(m.Content, &content)
+		if err != nil {
+			return nil, fmt.Errorf("text content is not a string: %w", err)
+		}
+		return []byte(content), nil
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedContentType, m.ContentType)
+	}
+}
+
+// CanonicalizeJSON re-encodes JSON with sorted object keys and no whitespace, so equal contents have equal bytes.
+// Numbers are kept as they were written, so large integers and decimals don't lose precision.
+func CanonicalizeJSON(raw json.RawMessage) (json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var content interface{}
+	err := decoder.Decode(&content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode content: %w", err)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("failed to decode content: trailing data")
+	}
+
+	// maps are encoded with sorted keys. We don't escape HTML, as it would change strings for no reason
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode content: %w", err)
+	}
+
+	return bytes.TrimSuffix(buffer.Bytes(), []byte("\n")), nil
+}
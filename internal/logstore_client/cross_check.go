@@ -0,0 +1,151 @@
+package logstore_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCrossCheckFailed is returned when the endpoints disagree on a window, or when not enough of them answered
+var ErrCrossCheckFailed = errors.New("cross check failed")
+
+// CrossCheckDiff is the difference between the response of an endpoint and the reference one
+type CrossCheckDiff struct {
+	Endpoint string
+	// Missing are messages the reference endpoint returned, but this one didn't
+	Missing []MessageRef
+	// Extra are messages this endpoint returned, but the reference one didn't
+	Extra []MessageRef
+	// Different are messages both returned, but with different contents or signatures
+	Different []MessageRef
+}
+
+func (d CrossCheckDiff) isEmpty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Different) == 0
+}
+
+func (d CrossCheckDiff) String() string {
+	return fmt.Sprintf("%s: missing %v, extra %v, different %v", d.Endpoint, d.Missing, d.Extra, d.Different)
+}
+
+// CrossCheckError reports the endpoints that disagree with the reference endpoint on a window
+type CrossCheckError struct {
+	Reference string
+	Diffs     []CrossCheckDiff
+}
+
+func (e *CrossCheckError) Error() string {
+	diffs := make([]string, 0, len(e.Diffs))
+	for _, diff := range e.Diffs {
+		diffs = append(diffs, diff.String())
+	}
+	return fmt.Sprintf("%s: responses disagree with %s: %s", ErrCrossCheckFailed, e.Reference, strings.Join(diffs, "; "))
+}
+
+func (e *CrossCheckError) Unwrap() error {
+	return ErrCrossCheckFailed
+}
+
+type crossCheckResponse struct {
+	endpoint string
+	messages []JSONStreamMessage
+	metadata *QueryMetadata
+}
+
+// crossCheckQueryRange queries the range on endpoints until crossCheckNodes of them answer,
+// and only returns the messages if all of them agree.
+func (c *LogStoreClient) crossCheckQueryRange(ctx context.Context, streamId string, from, to Cursor, partition int) ([]JSONStreamMessage, *QueryMetadata, error) {
+	var responses []crossCheckResponse
+	var errs []error
+
+	for _, endpoint := range c.endpointsInOrder(nil) {
+		if len(responses) == c.crossCheckNodes {
+			break
+		}
+
+		messages, metadata, err := c.queryRange(ctx, []string{endpoint}, streamId, from, to, partition)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		responses = append(responses, crossCheckResponse{endpoint: endpoint, messages: messages, metadata: metadata})
+	}
+
+	if len(responses) < c.crossCheckNodes {
+		return nil, nil, fmt.Errorf("%w: only %d of %d endpoints answered: %w", ErrCrossCheckFailed, len(responses), c.crossCheckNodes, errors.Join(errs...))
+	}
+
+	reference := responses[0]
+	crossCheckErr := &CrossCheckError{Reference: reference.endpoint}
+	for _, response := range responses[1:] {
+		diff, err := diffMessages(reference.messages, response.messages)
+		if err != nil {
+			return nil, nil, err
+		}
+		diff.Endpoint = response.endpoint
+
+		if !diff.isEmpty() {
+			crossCheckErr.Diffs = append(crossCheckErr.Diffs, diff)
+		}
+	}
+
+	if len(crossCheckErr.Diffs) > 0 {
+		return nil, nil, crossCheckErr
+	}
+
+	return reference.messages, reference.metadata, nil
+}
+
+// diffMessages compares the messages of an endpoint against the reference ones
+func diffMessages(reference, other []JSONStreamMessage) (CrossCheckDiff, error) {
+	diff := CrossCheckDiff{}
+
+	referenceFingerprints, err := fingerprintMessages(reference)
+	if err != nil {
+		return diff, err
+	}
+	otherFingerprints, err := fingerprintMessages(other)
+	if err != nil {
+		return diff, err
+	}
+
+	// iterating over the slices keeps the diff in message order
+	for _, message := range reference {
+		ref := message.Ref()
+		otherFingerprint, ok := otherFingerprints[ref]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, ref)
+		case otherFingerprint != referenceFingerprints[ref]:
+			diff.Different = append(diff.Different, ref)
+		}
+	}
+
+	for _, message := range other {
+		ref := message.Ref()
+		if _, ok := referenceFingerprints[ref]; !ok {
+			diff.Extra = append(diff.Extra, ref)
+		}
+	}
+
+	return diff, nil
+}
+
+// fingerprintMessages encodes each message, so messages with the same reference can be compared as a whole
+func fingerprintMessages(messages []JSONStreamMessage) (map[MessageRef]string, error) {
+	fingerprints := make(map[MessageRef]string, len(messages))
+	for _, message := range messages {
+		encoded, err := json.Marshal(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode message %s: %w", message.Ref(), err)
+		}
+		fingerprints[message.Ref()] = string(encoded)
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,60 @@
+package logstore_client
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+)
+
+// MaxSequenceNumber is the sequence number of the last possible message of a millisecond
+const MaxSequenceNumber = math.MaxInt32
+
+// Cursor is a position in a stream. Unlike timestamps alone, it can point between messages sharing a timestamp,
+// using the sequence numbers of the messages, so ranges are exact.
+type Cursor struct {
+	Timestamp      int64
+	SequenceNumber int
+}
+
+// StartOf is the cursor of the first possible message at the timestamp
+func StartOf(timestamp int64) Cursor {
+	return Cursor{Timestamp: timestamp}
+}
+
+// EndOf is the cursor of the last possible message at the timestamp
+func EndOf(timestamp int64) Cursor {
+	return Cursor{Timestamp: timestamp, SequenceNumber: MaxSequenceNumber}
+}
+
+// CursorOf is the cursor pointing at the message
+func CursorOf(message *JSONStreamMessage) Cursor {
+	return Cursor{Timestamp: message.Timestamp, SequenceNumber: message.SequenceNumber}
+}
+
+// Compare returns -1, 0 or 1 if the cursor is before, at, or after the other one
+func (c Cursor) Compare(other Cursor) int {
+	if c.Timestamp != other.Timestamp {
+		return cmp.Compare(c.Timestamp, other.Timestamp)
+	}
+	return cmp.Compare(c.SequenceNumber, other.SequenceNumber)
+}
+
+// Next is the cursor right after this one
+func (c Cursor) Next() Cursor {
+	if c.SequenceNumber >= MaxSequenceNumber {
+		return StartOf(c.Timestamp + 1)
+	}
+	return Cursor{Timestamp: c.Timestamp, SequenceNumber: c.SequenceNumber + 1}
+}
+
+// Previous is the cursor right before this one
+func (c Cursor) Previous() Cursor {
+	if c.SequenceNumber <= 0 {
+		return EndOf(c.Timestamp - 1)
+	}
+	return Cursor{Timestamp: c.Timestamp, SequenceNumber: c.SequenceNumber - 1}
+}
+
+func (c Cursor) String() string {
+	return fmt.Sprintf("%d/%d", c.Timestamp, c.SequenceNumber)
+}
@@ -0,0 +1,120 @@
+package logstore_client
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Streamr encryption types
+const (
+	EncryptionTypeNone = 0
+	EncryptionTypeRSA  = 1
+	EncryptionTypeAES  = 2
+)
+
+var (
+	ErrUnsupportedEncryption = errors.New("unsupported encryption type")
+	ErrMissingGroupKey       = errors.New("missing group key")
+	ErrDecryptionFailed      = errors.New("decryption failed")
+)
+
+// GroupKeys maps group key ids to AES-256 keys, used by publishers to encrypt stream messages
+type GroupKeys map[string][]byte
+
+// ParseGroupKeys parses keys in the `<groupKeyId>:<hex key>,<groupKeyId>:<hex key>` format
+func ParseGroupKeys(value string) (GroupKeys, error) {
+	keys := make(GroupKeys)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// group key ids may contain colons, so the key is after the last one
+		separatorIndex := strings.LastIndex(entry, ":")
+		if separatorIndex == -1 {
+			return nil, fmt.Errorf("invalid group key: %s", entry)
+		}
+
+		key, err := hex.DecodeString(strings.TrimPrefix(entry[separatorIndex+1:], "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid group key %s: %w", entry[:separatorIndex], err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid group key %s: expected 32 bytes, got %d", entry[:separatorIndex], len(key))
+		}
+
+		keys[entry[:separatorIndex]] = key
+	}
+	return keys, nil
+}
+
+// IsEncrypted tells if the content of the message is ciphertext
+func (m *JSONStreamMessage) IsEncrypted() bool {
+	return m.EncryptionType != EncryptionTypeNone
+}
+
+// DecryptContent replaces the encrypted content of the message by its plaintext.
+// Streamr encrypts contents with AES-256-CTR, sending them as hex of the IV followed by the ciphertext.
+// Signatures are made over the encrypted content, so they must be verified before decrypting.
+func DecryptContent(message *JSONStreamMessage, keys GroupKeys) error {
+	if message.EncryptionType != EncryptionTypeAES {
+		return fmt.Errorf("%w: %d", ErrUnsupportedEncryption, message.EncryptionType)
+	}
+
+	key, ok := keys[message.GroupKeyId]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingGroupKey, message.GroupKeyId)
+	}
+
+	ciphertextHex, err := serializedContent(message.Content)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := hex.DecodeString(ciphertextHex)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return fmt.Errorf("%w: content is shorter than the IV", ErrDecryptionFailed)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	cipher.NewCTR(block, ciphertext[:aes.BlockSize]).XORKeyStream(plaintext, ciphertext[aes.BlockSize:])
+
+	// the plaintext is transported like unencrypted content of the same type would be
+	var rawContent json.RawMessage
+	switch message.ContentType {
+	case ContentTypeJSON:
+		rawContent = plaintext
+	case ContentTypeBinary:
+		rawContent, err = json.Marshal(hex.EncodeToString(plaintext))
+	case ContentTypeText:
+		rawContent, err = json.Marshal(string(plaintext))
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedContentType, message.ContentType)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	// a wrong key yields garbage instead of failing. We can only detect it for JSON content
+	if !json.Valid(rawContent) {
+		return fmt.Errorf("%w: content is not valid JSON", ErrDecryptionFailed)
+	}
+
+	message.Content = rawContent
+	message.EncryptionType = EncryptionTypeNone
+	return nil
+}
@@ -0,0 +1,99 @@
+package logstore_client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized      = errors.New("unauthorized")
+	ErrStreamNotFound    = errors.New("stream not found")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrServerError       = errors.New("server error")
+	ErrUnexpectedStatus  = errors.New("unexpected status")
+	ErrMalformedResponse = errors.New("malformed response")
+)
+
+// maxErrorBodySize limits how much of an error response body is kept, as it may be a whole HTML page
+const maxErrorBodySize = 512
+
+// HTTPError is returned when the log store node answers with a non-successful status code.
+// It wraps one of the sentinel errors above, so callers can use errors.Is to tell them apart.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the wait requested by the node with the Retry-After header, if any
+	RetryAfter time.Duration
+	kind       error
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s: status %d", e.kind, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: status %d: %s", e.kind, e.StatusCode, e.Body)
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.kind
+}
+
+// checkResponse returns a typed error if the response is not successful
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+
+	var kind error
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		kind = ErrStreamNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case resp.StatusCode >= 500:
+		kind = ErrServerError
+	default:
+		kind = ErrUnexpectedStatus
+	}
+
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       strings.TrimSpace(string(body)),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		kind:       kind,
+	}
+}
+
+// parseRetryAfter parses the Retry-After header, which is either in seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// malformedResponseError wraps a decoding error, so it's distinguishable from an empty response.
+// The cause is kept, so a body cut while it was read, such as io.ErrUnexpectedEOF, can be retried.
+func malformedResponseError(err error) error {
+	return fmt.Errorf("%w: %w", ErrMalformedResponse, err)
+}
@@ -0,0 +1,559 @@
+package logstore_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// MessageIterator yields messages one at a time, so big windows don't need to be held in memory.
+//
+//	it := client.IterateRange(ctx, streamId, from, to, partition)
+//	defer it.Close()
+//	for it.Next() {
+//		message := it.Message()
+//	}
+//	if it.Err() != nil { ... }
+type MessageIterator interface {
+	// Next advances to the next message, returning false when there are no more messages or an error happened
+	Next() bool
+	// Message returns the current message
+	Message() JSONStreamMessage
+	// Metadata describes the query. It's only complete after Next returns false
+	Metadata() *QueryMetadata
+	// Err returns the error that stopped the iteration, if any
+	Err() error
+	// Close releases open responses. It's safe to call it more than once
+	Close() error
+}
+
+// IterateRange iterates over all messages of a partition between from and to (both inclusive),
+// following the pages of the log store node. See [LogStoreClient.QueryRange].
+func (c *LogStoreClient) IterateRange(ctx context.Context, streamId string, from, to int64, partition int) MessageIterator {
+	return c.IterateRangeBetween(ctx, streamId, StartOf(from), EndOf(to), partition)
+}
+
+// IterateRangeBetween iterates over all messages of a partition between the cursors (both inclusive).
+// Cursors may point between messages sharing a timestamp, which timestamps alone can't express.
+func (c *LogStoreClient) IterateRangeBetween(ctx context.Context, streamId string, from, to Cursor, partition int) MessageIterator {
+	// cross-checking needs the responses of all nodes before accepting any message
+	if c.crossCheckNodes > 1 {
+		messages, metadata, err := c.crossCheckQueryRange(ctx, streamId, from, to, partition)
+		return newSliceIterator(messages, metadata, err)
+	}
+
+	return c.iterateRange(ctx, nil, streamId, from, to, partition)
+}
+
+// IterateAllPartitions iterates over the range on every partition of the stream, merging them
+// in the same order as [LogStoreClient.QueryAllPartitions].
+func (c *LogStoreClient) IterateAllPartitions(ctx context.Context, streamId string, from, to int64) (MessageIterator, error) {
+	return c.IterateAllPartitionsBetween(ctx, streamId, StartOf(from), EndOf(to))
+}
+
+// IterateAllPartitionsBetween iterates over the messages between the cursors (both inclusive) on every partition of the stream.
+// See [LogStoreClient.IterateAllPartitions].
+func (c *LogStoreClient) IterateAllPartitionsBetween(ctx context.Context, streamId string, from, to Cursor) (MessageIterator, error) {
+	partitionCount, err := c.GetStreamPartitionCount(ctx, streamId)
+	if err != nil {
+		return nil, err
+	}
+
+	// every partition holds a request open until its page is drained, so fewer slots would wait forever
+	if maxConcurrency := c.limiter.maxConcurrency(); c.crossCheckNodes <= 1 && maxConcurrency > 0 && maxConcurrency < partitionCount {
+		return nil, fmt.Errorf("max concurrency of %d requests is lower than the %d partitions of stream %s, which are read at once", maxConcurrency, partitionCount, streamId)
+	}
+
+	iterators := make([]MessageIterator, 0, partitionCount)
+	for partition := 0; partition < partitionCount; partition++ {
+		iterators = append(iterators, c.IterateRangeBetween(ctx, streamId, from, to, partition))
+	}
+
+	return newMergeIterator(iterators), nil
+}
+
+// rangeIterator streams the messages of a partition, requesting the next page when the current one is drained.
+// The log store node signals more pages with `metadata.hasNext`. Next pages start from the timestamp of the
+// last message received, so messages already received at that timestamp are skipped to avoid duplicates.
+// Sequence numbers bound the range on the node, and messages outside the cursors are dropped anyway,
+// in case the node ignores them.
+// A page that fails while it's read, such as a dropped connection, is opened again from the last timestamp received,
+// the same way as a next page, up to the attempts of the retry policy.
+type rangeIterator struct {
+	c         *LogStoreClient
+	ctx       context.Context
+	endpoints []string
+	streamId  string
+	from      Cursor
+	to        Cursor
+	partition int
+
+	page     *pageDecoder
+	pageFrom int64
+	// messages received on the current page, excluding duplicates
+	pageMessages int
+	// references of the messages received at the last timestamp seen
+	lastTimestamp int64
+	boundaryRefs  map[MessageRef]struct{}
+	// attempts to read the current page, and the backoff between them
+	pageAttempts int
+	pageBackOff  *backoff.ExponentialBackOff
+
+	current  JSONStreamMessage
+	metadata QueryMetadata
+	err      error
+	done     bool
+}
+
+func (c *LogStoreClient) iterateRange(ctx context.Context, endpoints []string, streamId string, from, to Cursor, partition int) *rangeIterator {
+	return &rangeIterator{
+		c:             c,
+		ctx:           ctx,
+		endpoints:     endpoints,
+		streamId:      streamId,
+		from:          from,
+		to:            to,
+		partition:     partition,
+		pageFrom:      from.Timestamp,
+		lastTimestamp: from.Timestamp,
+		boundaryRefs:  make(map[MessageRef]struct{}),
+	}
+}
+
+func (it *rangeIterator) Next() bool {
+	for !it.done {
+		if it.page == nil {
+			it.err = it.openPage()
+			if it.err != nil {
+				return it.stop()
+			}
+		}
+
+		message, err := it.page.next()
+		if err != nil {
+			it.err = it.reopenPage(err)
+			if it.err != nil {
+				return it.stop()
+			}
+			continue
+		}
+
+		if message == nil {
+			if !it.nextPage() {
+				return it.stop()
+			}
+			continue
+		}
+
+		ref := message.Ref()
+		if message.Timestamp == it.pageFrom {
+			if _, seen := it.boundaryRefs[ref]; seen {
+				continue
+			}
+		}
+
+		if message.Timestamp != it.lastTimestamp {
+			it.lastTimestamp = message.Timestamp
+			it.boundaryRefs = make(map[MessageRef]struct{})
+		}
+		it.boundaryRefs[ref] = struct{}{}
+
+		it.pageMessages++
+		cursor := CursorOf(message)
+		if cursor.Compare(it.from) < 0 || cursor.Compare(it.to) > 0 {
+			it.metadata.OutOfRange++
+			continue
+		}
+
+		it.current = *message
+		return true
+	}
+	return false
+}
+
+func (it *rangeIterator) openPage() error {
+	// http://<endpoint>/stores/:id/data/partitions/:partition/range?from=:from&to=:to
+	encodedStreamId := url.PathEscape(it.streamId)
+	q := url.Values{}
+	q.Add("fromTimestamp", strconv.FormatInt(it.pageFrom, 10))
+	q.Add("toTimestamp", strconv.FormatInt(it.to.Timestamp, 10))
+	// sequence numbers are only sent when they bound the range, so plain timestamp queries stay the same
+	if it.pageFrom == it.from.Timestamp && it.from.SequenceNumber > 0 {
+		q.Add("fromSequenceNumber", strconv.Itoa(it.from.SequenceNumber))
+	}
+	if it.to.SequenceNumber < MaxSequenceNumber {
+		q.Add("toSequenceNumber", strconv.Itoa(it.to.SequenceNumber))
+	}
+
+	body, err := it.c.openStream(it.ctx, it.endpoints, "/stores/"+encodedStreamId+"/data/partitions/"+strconv.Itoa(it.partition)+"/range", q, true)
+	if err != nil {
+		return err
+	}
+
+	it.page = newPageDecoder(body)
+	it.pageMessages = 0
+	return nil
+}
+
+// reopenPage closes a page that failed while it was read, so it's opened again from the last timestamp received.
+// The error is returned if it can't go away by retrying, or the attempts are over.
+func (it *rangeIterator) reopenPage(err error) error {
+	_ = it.page.Close()
+	it.page = nil
+
+	it.pageAttempts++
+	if it.pageAttempts >= it.c.retryPolicy.MaxAttempts || !isRetryableError(err) {
+		return err
+	}
+
+	if it.pageBackOff == nil {
+		it.pageBackOff = it.c.retryPolicy.newBackOff()
+	}
+	select {
+	case <-it.ctx.Done():
+		return errors.Join(err, it.ctx.Err())
+	case <-time.After(it.pageBackOff.NextBackOff()):
+	}
+
+	// messages received at the last timestamp are skipped, like on a next page
+	it.pageFrom = it.lastTimestamp
+	return nil
+}
+
+// nextPage closes the drained page, and tells if there's another one to fetch
+func (it *rangeIterator) nextPage() bool {
+	metadata := it.page.metadata
+	_ = it.page.Close()
+	it.page = nil
+	it.pageAttempts = 0
+	it.pageBackOff = nil
+
+	// the first page reports the total of the whole window.
+	// If it was opened again, it only reports the rest of the window, which still bounds what we must receive
+	if it.metadata.Pages == 0 {
+		it.metadata.TotalMessages = metadata.TotalMessages
+	}
+	it.metadata.Pages++
+
+	if !metadata.HasNext {
+		return false
+	}
+
+	// a page with more data to come, but nothing new, would make us loop forever
+	if it.pageMessages == 0 {
+		it.err = fmt.Errorf("no progress while paginating stream %s partition %d at timestamp %d", it.streamId, it.partition, it.pageFrom)
+		return false
+	}
+
+	it.pageFrom = it.lastTimestamp
+	return true
+}
+
+func (it *rangeIterator) stop() bool {
+	it.done = true
+	_ = it.Close()
+	return false
+}
+
+func (it *rangeIterator) Message() JSONStreamMessage {
+	return it.current
+}
+
+func (it *rangeIterator) Metadata() *QueryMetadata {
+	return &it.metadata
+}
+
+func (it *rangeIterator) Err() error {
+	return it.err
+}
+
+func (it *rangeIterator) Close() error {
+	if it.page == nil {
+		return nil
+	}
+	err := it.page.Close()
+	it.page = nil
+	return err
+}
+
+// pageDecoder decodes a `{"messages":[...],"metadata":{...}}` response while it's read,
+// yielding one message at a time. Fields may come in any order.
+type pageDecoder struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+
+	started     bool
+	inMessages  bool
+	sawMessages bool
+	finished    bool
+	metadata    JSONStreamMessageMetadata
+}
+
+func newPageDecoder(body io.ReadCloser) *pageDecoder {
+	return &pageDecoder{body: body, decoder: json.NewDecoder(body)}
+}
+
+// next returns the next message of the page, or nil when the page is over
+func (d *pageDecoder) next() (*JSONStreamMessage, error) {
+	if !d.started {
+		d.started = true
+		err := d.expectDelim('{')
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for !d.finished {
+		if d.inMessages {
+			if d.decoder.More() {
+				var message JSONStreamMessage
+				err := d.decoder.Decode(&message)
+				if err != nil {
+					return nil, malformedResponseError(err)
+				}
+				return &message, nil
+			}
+
+			err := d.expectDelim(']')
+			if err != nil {
+				return nil, err
+			}
+			d.inMessages = false
+			continue
+		}
+
+		token, err := d.decoder.Token()
+		if err != nil {
+			return nil, malformedResponseError(err)
+		}
+
+		if token == json.Delim('}') {
+			d.finished = true
+			break
+		}
+
+		switch token {
+		case "messages":
+			// a missing messages field is not the same as an empty window
+			err = d.expectDelim('[')
+			if err != nil {
+				return nil, err
+			}
+			d.inMessages = true
+			d.sawMessages = true
+		case "metadata":
+			err = d.decoder.Decode(&d.metadata)
+		default:
+			var ignored json.RawMessage
+			err = d.decoder.Decode(&ignored)
+		}
+		if err != nil {
+			return nil, malformedResponseError(err)
+		}
+	}
+
+	if !d.sawMessages {
+		return nil, malformedResponseError(fmt.Errorf("missing messages field"))
+	}
+
+	return nil, nil
+}
+
+func (d *pageDecoder) expectDelim(delim json.Delim) error {
+	token, err := d.decoder.Token()
+	if err != nil {
+		return malformedResponseError(err)
+	}
+	if token != delim {
+		return malformedResponseError(fmt.Errorf("expected %s, got %v", delim, token))
+	}
+	return nil
+}
+
+func (d *pageDecoder) Close() error {
+	return d.body.Close()
+}
+
+// mergeIterator merges the messages of many iterators, such as one per partition.
+// Each iterator yields messages by timestamp, so we only need to buffer the messages of the smallest
+// timestamp across all of them, and sort those with [compareMessages] to have a deterministic order.
+type mergeIterator struct {
+	iterators []MessageIterator
+	heads     []*JSONStreamMessage
+	started   bool
+
+	buffer   []JSONStreamMessage
+	position int
+	current  JSONStreamMessage
+	err      error
+}
+
+func newMergeIterator(iterators []MessageIterator) *mergeIterator {
+	return &mergeIterator{
+		iterators: iterators,
+		heads:     make([]*JSONStreamMessage, len(iterators)),
+	}
+}
+
+func (it *mergeIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if !it.started {
+		it.started = true
+		for i := range it.iterators {
+			if !it.advance(i) {
+				return false
+			}
+		}
+	}
+
+	if it.position >= len(it.buffer) && !it.fill() {
+		return false
+	}
+
+	it.current = it.buffer[it.position]
+	it.position++
+	return true
+}
+
+// fill buffers all messages with the smallest timestamp among the iterators
+func (it *mergeIterator) fill() bool {
+	it.buffer = it.buffer[:0]
+	it.position = 0
+
+	var minTimestamp *int64
+	for _, head := range it.heads {
+		if head != nil && (minTimestamp == nil || head.Timestamp < *minTimestamp) {
+			minTimestamp = &head.Timestamp
+		}
+	}
+	if minTimestamp == nil {
+		return false
+	}
+	timestamp := *minTimestamp
+
+	for i := range it.iterators {
+		for it.heads[i] != nil && it.heads[i].Timestamp == timestamp {
+			it.buffer = append(it.buffer, *it.heads[i])
+			if !it.advance(i) {
+				return false
+			}
+		}
+	}
+
+	sort.SliceStable(it.buffer, func(i, j int) bool {
+		return compareMessages(&it.buffer[i], &it.buffer[j]) < 0
+	})
+
+	return true
+}
+
+// advance moves the head of an iterator, returning false if it failed
+func (it *mergeIterator) advance(i int) bool {
+	if it.iterators[i].Next() {
+		message := it.iterators[i].Message()
+		it.heads[i] = &message
+		return true
+	}
+
+	it.heads[i] = nil
+	if err := it.iterators[i].Err(); err != nil {
+		it.err = fmt.Errorf("failed to query partition %d: %w", i, err)
+		_ = it.Close()
+		return false
+	}
+	return true
+}
+
+func (it *mergeIterator) Message() JSONStreamMessage {
+	return it.current
+}
+
+func (it *mergeIterator) Metadata() *QueryMetadata {
+	metadata := &QueryMetadata{}
+	for _, iterator := range it.iterators {
+		metadata.TotalMessages += iterator.Metadata().TotalMessages
+		metadata.Pages += iterator.Metadata().Pages
+		metadata.OutOfRange += iterator.Metadata().OutOfRange
+	}
+	return metadata
+}
+
+func (it *mergeIterator) Err() error {
+	return it.err
+}
+
+func (it *mergeIterator) Close() error {
+	var err error
+	for _, iterator := range it.iterators {
+		if closeErr := iterator.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// sliceIterator iterates over messages already in memory
+type sliceIterator struct {
+	messages []JSONStreamMessage
+	metadata *QueryMetadata
+	position int
+	err      error
+}
+
+func newSliceIterator(messages []JSONStreamMessage, metadata *QueryMetadata, err error) *sliceIterator {
+	if metadata == nil {
+		metadata = &QueryMetadata{}
+	}
+	return &sliceIterator{messages: messages, metadata: metadata, err: err}
+}
+
+func (it *sliceIterator) Next() bool {
+	if it.err != nil || it.position >= len(it.messages) {
+		return false
+	}
+	it.position++
+	return true
+}
+
+func (it *sliceIterator) Message() JSONStreamMessage {
+	return it.messages[it.position-1]
+}
+
+func (it *sliceIterator) Metadata() *QueryMetadata {
+	return it.metadata
+}
+
+func (it *sliceIterator) Err() error {
+	return it.err
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+// drain reads all messages of an iterator into memory
+func drain(it MessageIterator) ([]JSONStreamMessage, *QueryMetadata, error) {
+	defer it.Close()
+
+	var messages []JSONStreamMessage
+	for it.Next() {
+		messages = append(messages, it.Message())
+	}
+
+	if it.Err() != nil {
+		return nil, nil, it.Err()
+	}
+
+	return messages, it.Metadata(), nil
+}
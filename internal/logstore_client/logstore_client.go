@@ -1,48 +1,127 @@
 package logstore_client
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/kwilteam/kwil-db/core/crypto/auth"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type LogStoreClient struct {
-	endpoint string
-	signer   auth.EthPersonalSigner
+	// endpoints are the log store nodes we fail over between. preferred is the index of the last healthy one
+	endpoints  []string
+	preferred  atomic.Int32
+	httpClient *http.Client
+
+	authRefreshInterval time.Duration
+	authenticator       *authenticator
+
+	// registry discovers the partition count of streams, if set. partitionCount is used without it,
+	// or when discovery fails, and defaults to 1
+	registry       *StreamRegistry // optional
+	partitionCount int
+
+	retryPolicy RetryPolicy
+
+	// crossCheckNodes is the number of endpoints that must agree on a range query. 1 or less disables it
+	crossCheckNodes int
+
+	// limiter throttles the requests sent to the endpoints
+	limiter *requestLimiter // optional
+
+	// subscriptionEndpoint is the websocket plugin of the Streamr node live messages come from. See [LogStoreClient.Subscribe]
+	subscriptionEndpoint string // optional
+	subscriptionApiKey   string
+
+	// partition counts are cached per stream, as they are read from the chain.
+	// Failed discoveries are not tried again before discoveryRetryAt, so an unavailable RPC doesn't stall every window
+	partitionCountsMu sync.Mutex
+	partitionCounts   map[string]int
+	discoveryRetryAt  map[string]time.Time
 }
 
-func NewLogStoreClient(endpoint string, signer auth.EthPersonalSigner) *LogStoreClient {
-	return &LogStoreClient{endpoint: endpoint, signer: signer}
+// partitionDiscoveryRetryInterval is how long a stream uses the configured partition count after its discovery failed
+const partitionDiscoveryRetryInterval = 5 * time.Minute
+
+// partitionDiscoveryTimeout bounds each call to the stream registry
+const partitionDiscoveryTimeout = 10 * time.Second
+
+// ClientOption configures optional behavior of the LogStoreClient
+type ClientOption func(*LogStoreClient)
+
+// WithHTTPClient sets the HTTP client used for every request. See [NewHTTPClient].
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *LogStoreClient) {
+		c.httpClient = httpClient
+	}
 }
 
-func (c *LogStoreClient) GetCurrentBlockHeight() (int64, error) {
-	return 0, fmt.Errorf("not implemented")
+// WithAuthRefreshInterval sets how long the signed authorization header is reused
+func WithAuthRefreshInterval(refreshInterval time.Duration) ClientOption {
+	return func(c *LogStoreClient) {
+		c.authRefreshInterval = refreshInterval
+	}
 }
 
-// FetchMessages fetches messages from the log store using a request
-func (c *LogStoreClient) FetchMessages(req *http.Request) ([]JSONStreamMessage, error) {
-	authHeader, err := createAuthHeader(c.signer)
-	if err != nil {
-		return nil, err
+// WithStreamRegistry enables discovering the partition count of streams from the registry.
+// The count set by [WithPartitionCount] is used while discovery fails.
+func WithStreamRegistry(registry *StreamRegistry) ClientOption {
+	return func(c *LogStoreClient) {
+		c.registry = registry
 	}
+}
 
-	req.Header.Add("authorization", authHeader)
+// WithPartitionCount sets the partition count of streams, 1 by default.
+// With a stream registry, it's only used when discovery fails.
+func WithPartitionCount(count int) ClientOption {
+	return func(c *LogStoreClient) {
+		c.partitionCount = count
+	}
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// WithCrossCheck makes range queries fetch the same window from n endpoints,
+// only accepting the messages when all responses agree. See [CrossCheckError].
+func WithCrossCheck(n int) ClientOption {
+	return func(c *LogStoreClient) {
+		c.crossCheckNodes = n
+	}
+}
 
-	if err != nil {
-		return nil, err
+// NewLogStoreClient creates a client for the given log store node endpoints.
+// Requests go to the last healthy endpoint, failing over to the next ones in order.
+func NewLogStoreClient(endpoints []string, signer auth.Signer, opts ...ClientOption) *LogStoreClient {
+	c := &LogStoreClient{
+		endpoints:           endpoints,
+		httpClient:          &http.Client{},
+		authRefreshInterval: DefaultAuthRefreshInterval,
+		partitionCount:      1,
+		partitionCounts:     make(map[string]int),
+		discoveryRetryAt:    make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.authenticator = newAuthenticator(signer, c.authRefreshInterval)
+	return c
+}
 
-	defer resp.Body.Close()
+// FetchMessages fetches messages from the log store, failing over between the endpoints
+func (c *LogStoreClient) FetchMessages(ctx context.Context, path string, query url.Values) (*StreamMessageResponse, error) {
+	return c.fetchMessages(ctx, nil, path, query)
+}
 
-	// parse response
-	body, err := io.ReadAll(resp.Body)
+func (c *LogStoreClient) fetchMessages(ctx context.Context, endpoints []string, path string, query url.Values) (*StreamMessageResponse, error) {
+	body, err := c.get(ctx, endpoints, path, query, true)
 	if err != nil {
 		return nil, err
 	}
@@ -50,76 +129,222 @@ func (c *LogStoreClient) FetchMessages(req *http.Request) ([]JSONStreamMessage,
 	return decodeStreamMessageResponse(body)
 }
 
-func (c *LogStoreClient) GetFirstMessageTimestamp(streamId string) (int64, error) {
-	// http://<endpoint>/stores/:id/data/partitions/:partition/last?count=-1 (count=-1 means get the first message)
+// get sends a GET request to the path, returning the body of the first endpoint that succeeds. See [LogStoreClient.send].
+func (c *LogStoreClient) get(ctx context.Context, endpoints []string, path string, query url.Values, authenticated bool) ([]byte, error) {
+	var body []byte
+	err := c.send(ctx, endpoints, path, query, authenticated, func(newRequest requestBuilder) error {
+		var err error
+		body, err = c.doWithRetry(newRequest)
+		return err
+	})
+	return body, err
+}
 
-	encodedStreamId := url.PathEscape(streamId)
-	req, err := http.NewRequest("GET", c.endpoint+"/stores/"+encodedStreamId+"/data/partitions/0/last", nil)
-	if err != nil {
-		panic(err)
+// openStream sends a GET request to the path, returning the open body of the first endpoint that succeeds,
+// so it can be decoded while it's read. See [LogStoreClient.send].
+func (c *LogStoreClient) openStream(ctx context.Context, endpoints []string, path string, query url.Values, authenticated bool) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := c.send(ctx, endpoints, path, query, authenticated, func(newRequest requestBuilder) error {
+		var err error
+		body, err = c.openWithRetry(newRequest)
+		return err
+	})
+	return body, err
+}
+
+// send runs the operation on each endpoint in turn until one succeeds, with a builder of GET requests to the path.
+// With nil endpoints, it starts from the last healthy one, so a node that is down doesn't slow down every request.
+func (c *LogStoreClient) send(ctx context.Context, endpoints []string, path string, query url.Values, authenticated bool, operation func(newRequest requestBuilder) error) error {
+	var errs []error
+	for _, endpoint := range c.endpointsInOrder(endpoints) {
+		err := c.sendTo(ctx, endpoint, path, query, authenticated, operation)
+
+		// the cached header may have expired on the node side, so we sign a new one and try once more
+		if authenticated && errors.Is(err, ErrUnauthorized) {
+			c.authenticator.Invalidate()
+			err = c.sendTo(ctx, endpoint, path, query, authenticated, operation)
+		}
+
+		if err == nil {
+			c.setPreferred(endpoint)
+			return nil
+		}
+
+		// the caller gave up, other endpoints won't help
+		if ctx.Err() != nil {
+			return err
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", endpoint, err))
 	}
 
-	q := req.URL.Query()
+	return errors.Join(errs...)
+}
+
+// requestBuilder builds a new request for each attempt, so the authorization header is always the current one
+type requestBuilder func() (*http.Request, error)
+
+func (c *LogStoreClient) sendTo(ctx context.Context, endpoint, path string, query url.Values, authenticated bool, operation func(newRequest requestBuilder) error) error {
+	return operation(func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint+path, nil)
+		if err != nil {
+			panic(err)
+		}
+		req.URL.RawQuery = query.Encode()
+
+		if authenticated {
+			authHeader, err := c.authenticator.Header()
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Add("authorization", authHeader)
+		}
+
+		return req, nil
+	})
+}
+
+// endpointsInOrder returns the endpoints to try for a request. When none are given,
+// it's all the client endpoints, rotated so the preferred one comes first.
+func (c *LogStoreClient) endpointsInOrder(endpoints []string) []string {
+	if endpoints != nil {
+		return endpoints
+	}
+
+	preferred := int(c.preferred.Load())
+	return append(append([]string{}, c.endpoints[preferred:]...), c.endpoints[:preferred]...)
+}
+
+func (c *LogStoreClient) setPreferred(endpoint string) {
+	for i, e := range c.endpoints {
+		if e == endpoint {
+			c.preferred.Store(int32(i))
+			return
+		}
+	}
+}
+
+func (c *LogStoreClient) GetFirstMessageTimestamp(ctx context.Context, streamId string) (int64, error) {
+	// http://<endpoint>/stores/:id/data/partitions/:partition/last?count=-1 (count=-1 means get the first message)
+
+	encodedStreamId := url.PathEscape(streamId)
+	q := url.Values{}
 	q.Add("count", "-1")
-	req.URL.RawQuery = q.Encode()
 
-	streamMessageResponse, err := c.FetchMessages(req)
+	streamMessageResponse, err := c.FetchMessages(ctx, "/stores/"+encodedStreamId+"/data/partitions/0/last", q)
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch messages: %w", err)
 	}
 
 	// if there's no message, return 0
-	if len(streamMessageResponse) == 0 {
+	if len(streamMessageResponse.Messages) == 0 {
 		return 0, nil
 	}
 
-	return streamMessageResponse[0].Timestamp, nil
+	return streamMessageResponse.Messages[0].Timestamp, nil
 }
 
-func (c *LogStoreClient) GetLatestMessageTimestamp(streamId string) (int64, error) {
+func (c *LogStoreClient) GetLatestMessageTimestamp(ctx context.Context, streamId string) (int64, error) {
 	// http://<endpoint>/stores/:id/data/partitions/:partition/last?count=1 (count=1 means get the last message)
 
 	encodedStreamId := url.PathEscape(streamId)
-	req, err := http.NewRequest("GET", c.endpoint+"/stores/"+encodedStreamId+"/data/partitions/0/last", nil)
-	if err != nil {
-		panic(err)
-	}
-
-	q := req.URL.Query()
+	q := url.Values{}
 	q.Add("count", "1")
-	req.URL.RawQuery = q.Encode()
 
-	streamMessageResponse, err := c.FetchMessages(req)
+	streamMessageResponse, err := c.FetchMessages(ctx, "/stores/"+encodedStreamId+"/data/partitions/0/last", q)
 
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch messages: %w", err)
 	}
 
-	return streamMessageResponse[0].Timestamp, nil
+	// if there's no message, return 0
+	if len(streamMessageResponse.Messages) == 0 {
+		return 0, nil
+	}
+
+	return streamMessageResponse.Messages[0].Timestamp, nil
 }
 
-func (c *LogStoreClient) GetStreamPartitionCount(streamId string) (int, error) {
-	return 0, fmt.Errorf("not implemented")
+// GetStreamPartitionCount gets the number of partitions of a stream.
+// With a stream registry, the count is discovered once and cached. Otherwise, or while discovery fails,
+// it's the configured partition count.
+func (c *LogStoreClient) GetStreamPartitionCount(ctx context.Context, streamId string) (int, error) {
+	if c.registry == nil {
+		return c.partitionCount, nil
+	}
+
+	c.partitionCountsMu.Lock()
+	retryAt, failed := c.discoveryRetryAt[streamId]
+	c.partitionCountsMu.Unlock()
+	if failed && time.Now().Before(retryAt) {
+		return c.partitionCount, nil
+	}
+
+	count, err := c.DiscoverStreamPartitionCount(ctx, streamId)
+	if err != nil {
+		// the caller may be done, which says nothing about the registry
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+
+		c.partitionCountsMu.Lock()
+		c.discoveryRetryAt[streamId] = time.Now().Add(partitionDiscoveryRetryInterval)
+		c.partitionCountsMu.Unlock()
+		return c.partitionCount, nil
+	}
+	return count, nil
 }
 
-func (c *LogStoreClient) QueryAllPartitions(streamId string, from, to int64) ([]JSONStreamMessage, error) {
-	return c.QueryRange(streamId, from, to, 0)
+// DiscoverStreamPartitionCount reads the partition count of a stream from the stream registry, caching it
+func (c *LogStoreClient) DiscoverStreamPartitionCount(ctx context.Context, streamId string) (int, error) {
+	if c.registry == nil {
+		return 0, fmt.Errorf("no stream registry configured to discover the partitions of stream %s", streamId)
+	}
+
+	c.partitionCountsMu.Lock()
+	defer c.partitionCountsMu.Unlock()
+
+	if count, ok := c.partitionCounts[streamId]; ok {
+		return count, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, partitionDiscoveryTimeout)
+	defer cancel()
+
+	metadata, err := c.registry.GetStreamMetadata(ctx, streamId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to discover partitions: %w", err)
+	}
+
+	c.partitionCounts[streamId] = metadata.Partitions
+	delete(c.discoveryRetryAt, streamId)
+	return metadata.Partitions, nil
 }
 
-func (c *LogStoreClient) QueryRange(streamId string, from, to int64, partition int) ([]JSONStreamMessage, error) {
-	// http://<endpoint>/stores/:id/data/partitions/:partition/range?from=:from&to=:to
-	encodedStreamId := url.PathEscape(streamId)
-	req, err := http.NewRequest("GET", c.endpoint+"/stores/"+encodedStreamId+"/data/partitions/"+strconv.Itoa(partition)+"/range", nil)
+// QueryAllPartitions queries the range on every partition of the stream, merging the results.
+// Messages are sorted by (timestamp, sequenceNumber, partition), so every validator builds the same resolution.
+// For big windows, prefer [LogStoreClient.IterateAllPartitions].
+func (c *LogStoreClient) QueryAllPartitions(ctx context.Context, streamId string, from, to int64) ([]JSONStreamMessage, *QueryMetadata, error) {
+	it, err := c.IterateAllPartitions(ctx, streamId, from, to)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	q := req.URL.Query()
-	q.Add("fromTimestamp", strconv.FormatInt(from, 10))
-	q.Add("toTimestamp", strconv.FormatInt(to, 10))
-	req.URL.RawQuery = q.Encode()
+	return drain(it)
+}
+
+// QueryRange queries all messages of a partition between from and to (both inclusive).
+// The log store node paginates big responses, signaling it with `metadata.hasNext`. We keep fetching
+// pages until the window is drained, so callers always get the complete set of messages.
+// The returned metadata carries the total reported by the node, so callers can check it against the
+// number of messages received.
+// For big windows, prefer [LogStoreClient.IterateRange].
+func (c *LogStoreClient) QueryRange(ctx context.Context, streamId string, from, to int64, partition int) ([]JSONStreamMessage, *QueryMetadata, error) {
+	return drain(c.IterateRange(ctx, streamId, from, to, partition))
+}
 
-	return c.FetchMessages(req)
+func (c *LogStoreClient) queryRange(ctx context.Context, endpoints []string, streamId string, from, to Cursor, partition int) ([]JSONStreamMessage, *QueryMetadata, error) {
+	return drain(c.iterateRange(ctx, endpoints, streamId, from, to, partition))
 }
 
 /*
@@ -135,69 +360,122 @@ type JSONPartitionReadyResponse struct {
 	Ready bool `json:"ready"`
 }
 
-func (c *LogStoreClient) IsPartitionReady(streamId string, partition int) (bool, error) {
-	req, err := http.NewRequest("GET", c.endpoint+"/stores/"+url.PathEscape(streamId)+"/partitions/"+strconv.Itoa(partition)+"/ready", nil)
-	if err != nil {
-		panic(err)
-	}
-
-	q := req.URL.Query()
+func (c *LogStoreClient) IsPartitionReady(ctx context.Context, streamId string, partition int) (bool, error) {
+	q := url.Values{}
 	q.Add("timeout", "30000")
-	req.URL.RawQuery = q.Encode()
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
 
+	body, err := c.get(ctx, nil, "/stores/"+url.PathEscape(streamId)+"/partitions/"+strconv.Itoa(partition)+"/ready", q, false)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if partition is ready: %w", err)
 	}
 
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false, fmt.Errorf("failed to read response body: %w", err)
-	}
-
 	var response JSONPartitionReadyResponse
 	err = json.Unmarshal(body, &response)
 	if err != nil {
-		return false, fmt.Errorf("failed to unmarshal response body: %w", err)
+		return false, malformedResponseError(err)
 	}
 
 	return response.Ready, nil
 }
 
 type JSONStreamMessage struct {
-	StreamId        string      `json:"streamId"`
-	StreamPartition int         `json:"streamPartition"`
-	Timestamp       int64       `json:"timestamp"`
-	SequenceNumber  int         `json:"sequenceNumber"`
-	PublisherId     string      `json:"publisherId"`
-	MsgChainId      string      `json:"msgChainId"`
-	MessageType     int         `json:"messageType"`
-	ContentType     int         `json:"contentType"`
-	EncryptionType  int         `json:"encryptionType"`
-	Content         interface{} `json:"content"`
-	SignatureType   int         `json:"signatureType"`
-	Signature       string      `json:"signature"`
+	StreamId        string          `json:"streamId"`
+	StreamPartition int             `json:"streamPartition"`
+	Timestamp       int64           `json:"timestamp"`
+	SequenceNumber  int             `json:"sequenceNumber"`
+	PublisherId     string          `json:"publisherId"`
+	MsgChainId      string          `json:"msgChainId"`
+	PrevMsgRef      *JSONMessageRef `json:"prevMsgRef"`
+	MessageType     int             `json:"messageType"`
+	ContentType     int             `json:"contentType"`
+	EncryptionType  int             `json:"encryptionType"`
+	GroupKeyId      string          `json:"groupKeyId"`
+	Content         json.RawMessage `json:"content"`
+	NewGroupKey     json.RawMessage `json:"newGroupKey"`
+	SignatureType   int             `json:"signatureType"`
+	Signature       string          `json:"signature"`
 }
 
-// create decoder from response body
-func decodeStreamMessageResponse(body []byte) ([]JSONStreamMessage, error) {
-	var response struct {
-		Messages []JSONStreamMessage `json:"messages"`
-		Metadata struct {
-			HasNext       bool   `json:"hasNext"`
-			TotalMessages int    `json:"totalMessages"`
-			Type          string `json:"type"`
-		} `json:"metadata"`
+// JSONMessageRef points to the previous message in the same message chain
+type JSONMessageRef struct {
+	Timestamp      int64 `json:"timestamp"`
+	SequenceNumber int   `json:"sequenceNumber"`
+}
+
+// compareMessages orders messages deterministically by (timestamp, sequenceNumber, partition).
+// Publisher and message chain are used as tie-breakers, as they may share all the other fields.
+func compareMessages(a, b *JSONStreamMessage) int {
+	switch {
+	case a.Timestamp != b.Timestamp:
+		return cmp.Compare(a.Timestamp, b.Timestamp)
+	case a.SequenceNumber != b.SequenceNumber:
+		return cmp.Compare(a.SequenceNumber, b.SequenceNumber)
+	case a.StreamPartition != b.StreamPartition:
+		return cmp.Compare(a.StreamPartition, b.StreamPartition)
+	case a.PublisherId != b.PublisherId:
+		return strings.Compare(a.PublisherId, b.PublisherId)
+	default:
+		return strings.Compare(a.MsgChainId, b.MsgChainId)
 	}
+}
+
+// MessageRef identifies a message inside a partition
+type MessageRef struct {
+	Timestamp      int64
+	SequenceNumber int
+	PublisherId    string
+	MsgChainId     string
+}
+
+func (r MessageRef) String() string {
+	return fmt.Sprintf("%d/%d/%s/%s", r.Timestamp, r.SequenceNumber, r.PublisherId, r.MsgChainId)
+}
+
+// Ref is the reference of the message inside its partition
+func (m *JSONStreamMessage) Ref() MessageRef {
+	return MessageRef{
+		Timestamp:      m.Timestamp,
+		SequenceNumber: m.SequenceNumber,
+		PublisherId:    m.PublisherId,
+		MsgChainId:     m.MsgChainId,
+	}
+}
+
+type JSONStreamMessageMetadata struct {
+	HasNext       bool   `json:"hasNext"`
+	TotalMessages int    `json:"totalMessages"`
+	Type          string `json:"type"`
+}
+
+type StreamMessageResponse struct {
+	Messages []JSONStreamMessage       `json:"messages"`
+	Metadata JSONStreamMessageMetadata `json:"metadata"`
+}
+
+// QueryMetadata describes the result of a query that may span multiple pages
+type QueryMetadata struct {
+	// TotalMessages is the total of messages reported by the log store node for the query
+	TotalMessages int
+	// Pages is the number of pages fetched to drain the query
+	Pages int
+	// OutOfRange is the number of messages returned by the node outside the queried cursors, which were dropped.
+	// They are part of TotalMessages
+	OutOfRange int
+}
+
+// create decoder from response body
+func decodeStreamMessageResponse(body []byte) (*StreamMessageResponse, error) {
+	var response StreamMessageResponse
 
 	err := json.Unmarshal(body, &response)
 	if err != nil {
-		return nil, err
+		return nil, malformedResponseError(err)
+	}
+
+	// a missing messages field is not the same as an empty window
+	if response.Messages == nil {
+		return nil, malformedResponseError(fmt.Errorf("missing messages field"))
 	}
 
-	return response.Messages, nil
+	return &response, nil
 }
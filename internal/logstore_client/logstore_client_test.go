@@ -1,48 +1,943 @@
 package logstore_client
 
 import (
-	"fmt"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
 	"github.com/kwilteam/kwil-db/core/crypto"
 	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"github.com/usherlabs/kwil-ls-oracle/internal/fake_logstore"
 	"gotest.tools/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// testSigner is the signer of the tests, a fixed secp256k1 key
+func testSigner(t *testing.T) *auth.EthPersonalSigner {
+	t.Helper()
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex("0000000000000000000000000000000000000000000000000000000000000022")
+	assert.NilError(t, err)
+	return &auth.EthPersonalSigner{Key: *privateKey}
+}
+
 func Test_GetFirstMessageTimestamp(t *testing.T) {
+	const streamId = "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo"
+
 	tests := []struct {
-		name     string
-		prepare  func() (*LogStoreClient, error)
-		streamId string
-		wantErr  bool
+		name      string
+		messages  []fake_logstore.Message
+		wantFirst int64
+		wantLast  int64
 	}{
 		{
-			name:     "Normal Case",
-			streamId: "0xd37dc4d7e2c1bdf3edd89db0e505394ea69af43d/kwil-demo",
-			prepare: func() (*LogStoreClient, error) {
-				privateKey, err := crypto.Secp256k1PrivateKeyFromHex("0000000000000000000000000000000000000000000000000000000000000022")
-				if err != nil {
-					return nil, fmt.Errorf("failed to parse private key: %w", err)
-				}
-				signer := auth.EthPersonalSigner{
-					Key: *privateKey,
-				}
-
-				return NewLogStoreClient("http://localhost:7773", signer), nil
+			name: "Normal Case",
+			messages: []fake_logstore.Message{
+				{StreamId: streamId, Timestamp: 30},
+				{StreamId: streamId, Timestamp: 10},
+				{StreamId: streamId, Timestamp: 20},
+				// other partitions are not considered
+				{StreamId: streamId, StreamPartition: 1, Timestamp: 5},
 			},
+			wantFirst: 10,
+			wantLast:  30,
+		},
+		{
+			name: "Empty stream",
 		},
 	}
 
+	signer := testSigner(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake_logstore.NewServer()
+			defer server.Close()
+			server.RequireAuth(true)
+			server.AddMessages(tt.messages...)
+
+			c := NewLogStoreClient([]string{server.URL}, signer)
+
+			first, err := c.GetFirstMessageTimestamp(context.Background(), streamId)
+			assert.NilError(t, err)
+			assert.Equal(t, first, tt.wantFirst)
+
+			last, err := c.GetLatestMessageTimestamp(context.Background(), streamId)
+			assert.NilError(t, err)
+			assert.Equal(t, last, tt.wantLast)
+		})
+	}
+}
+
+func Test_FakeLogStoreFaults(t *testing.T) {
+	signer := testSigner(t)
+
+	var messages []fake_logstore.Message
+	for i := int64(1); i <= 5; i++ {
+		messages = append(messages, fake_logstore.Message{StreamId: "stream", Timestamp: i, Content: json.RawMessage(`{}`)})
+	}
+
+	tests := []struct {
+		name    string
+		fault   fake_logstore.Fault
+		reject  bool
+		timeout time.Duration
+		wantErr error
+	}{
+		{name: "Server errors are retried", fault: fake_logstore.Fault{Path: "/range", Times: 2, StatusCode: http.StatusServiceUnavailable}},
+		{name: "Persistent server errors", fault: fake_logstore.Fault{Path: "/range", StatusCode: http.StatusBadGateway}, wantErr: ErrServerError},
+		{name: "Truncated pages are opened again", fault: fake_logstore.Fault{Path: "/range", Times: 2, TruncatePage: true}},
+		{name: "Persistent truncated pages", fault: fake_logstore.Fault{Path: "/range", TruncatePage: true}, wantErr: ErrMalformedResponse},
+		{name: "Auth failure", reject: true, wantErr: ErrUnauthorized},
+		{name: "Latency", fault: fake_logstore.Fault{Latency: time.Second}, timeout: 50 * time.Millisecond, wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := fake_logstore.NewServer()
+			defer server.Close()
+			server.SetPageSize(2)
+			server.AddMessages(messages...)
+			server.InjectFault(tt.fault)
+			server.RejectAuth(tt.reject)
+
+			c := NewLogStoreClient([]string{server.URL}, signer, WithRetryPolicy(RetryPolicy{
+				MaxAttempts:     3,
+				InitialInterval: time.Millisecond,
+			}))
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			got, metadata, err := c.QueryRange(ctx, "stream", 1, 5, 0)
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, len(got), 5)
+			assert.Equal(t, metadata.TotalMessages, 5)
+		})
+	}
+}
+
+func Test_QueryRangePagination(t *testing.T) {
+	// three messages sharing a timestamp at the page boundary, to make sure it is not duplicated nor skipped
+	pages := []string{
+		`{"messages":[{"timestamp":1,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":0}],"metadata":{"hasNext":true,"totalMessages":4}}`,
+		`{"messages":[{"timestamp":2,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":1}],"metadata":{"hasNext":true,"totalMessages":3}}`,
+		`{"messages":[{"timestamp":2,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":1},{"timestamp":3,"sequenceNumber":0}],"metadata":{"hasNext":false,"totalMessages":3}}`,
+	}
+	var requestedFrom []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedFrom = append(requestedFrom, r.URL.Query().Get("fromTimestamp"))
+		_, _ = w.Write([]byte(pages[len(requestedFrom)-1]))
+	}))
+	defer server.Close()
+
+	signer := testSigner(t)
+	c := NewLogStoreClient([]string{server.URL}, signer)
+
+	messages, metadata, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, requestedFrom, []string{"0", "2", "2"})
+	assert.Equal(t, metadata.Pages, 3)
+	assert.Equal(t, metadata.TotalMessages, 4)
+	assert.Equal(t, len(messages), 4)
+	for i, want := range []MessageRef{{Timestamp: 1}, {Timestamp: 2}, {Timestamp: 2, SequenceNumber: 1}, {Timestamp: 3}} {
+		assert.Equal(t, messages[i].Ref(), want)
+	}
+}
+
+func Test_QueryRangeTruncatedPage(t *testing.T) {
+	// the connection drops after the messages at timestamp 2 started, so the page is opened again from there
+	pages := []string{
+		`{"messages":[{"timestamp":1,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":0},{"timestamp":2,"seq`,
+		`{"messages":[{"timestamp":2,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":1},{"timestamp":3,"sequenceNumber":0}],"metadata":{"hasNext":false,"totalMessages":3}}`,
+	}
+	var requestedFrom []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedFrom = append(requestedFrom, r.URL.Query().Get("fromTimestamp"))
+		_, _ = w.Write([]byte(pages[len(requestedFrom)-1]))
+	}))
+	defer server.Close()
+
+	signer := testSigner(t)
+	c := NewLogStoreClient([]string{server.URL}, signer, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:     2,
+		InitialInterval: time.Millisecond,
+	}))
+
+	messages, metadata, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, requestedFrom, []string{"0", "2"})
+	assert.Equal(t, metadata.Pages, 1)
+	assert.Equal(t, len(messages), 4)
+	for i, want := range []MessageRef{{Timestamp: 1}, {Timestamp: 2}, {Timestamp: 2, SequenceNumber: 1}, {Timestamp: 3}} {
+		assert.Equal(t, messages[i].Ref(), want)
+	}
+}
+
+func Test_TypedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+	}{
+		{name: "Unauthorized", status: http.StatusUnauthorized, body: "<html>unauthorized</html>", wantErr: ErrUnauthorized},
+		{name: "Stream not found", status: http.StatusNotFound, body: "not found", wantErr: ErrStreamNotFound},
+		{name: "Rate limited", status: http.StatusTooManyRequests, wantErr: ErrRateLimited},
+		{name: "Server error", status: http.StatusInternalServerError, body: "<html>oops</html>", wantErr: ErrServerError},
+		{name: "Malformed body", status: http.StatusOK, body: "<html>ok</html>", wantErr: ErrMalformedResponse},
+		{name: "Missing messages", status: http.StatusOK, body: `{"metadata":{}}`, wantErr: ErrMalformedResponse},
+		{name: "Empty window", status: http.StatusOK, body: `{"messages":[],"metadata":{}}`},
+	}
+
+	signer := testSigner(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := NewLogStoreClient([]string{server.URL}, signer)
+			messages, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, len(messages), 0)
+		})
+	}
+}
+
+func Test_RetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "Recovers from server errors", statuses: []int{503, 429, 200}, maxAttempts: 3, wantAttempts: 3},
+		{name: "Gives up after max attempts", statuses: []int{503, 503, 503}, maxAttempts: 2, wantErr: ErrServerError, wantAttempts: 2},
+		// only signs the header again, without following the retry policy
+		{name: "Doesn't retry unauthorized", statuses: []int{401, 401, 200}, maxAttempts: 3, wantErr: ErrUnauthorized, wantAttempts: 2},
+		{name: "Disabled", statuses: []int{503, 200}, maxAttempts: 1, wantErr: ErrServerError, wantAttempts: 1},
+	}
+
+	signer := testSigner(t)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, err := tt.prepare()
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[attempts]
+				attempts++
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", "1")
+				}
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"messages":[],"metadata":{}}`))
+			}))
+			defer server.Close()
+
+			c := NewLogStoreClient([]string{server.URL}, signer, WithRetryPolicy(RetryPolicy{
+				MaxAttempts:     tt.maxAttempts,
+				InitialInterval: time.Millisecond,
+				MaxInterval:     time.Millisecond,
+			}))
+			_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+			assert.Equal(t, attempts, tt.wantAttempts)
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
 			assert.NilError(t, err)
-			got, err := c.GetFirstMessageTimestamp(tt.streamId)
+		})
+	}
+}
+
+func Test_MultipleEndpoints(t *testing.T) {
+	newServer := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+		}))
+	}
+	down := newServer(http.StatusBadGateway, "")
+	defer down.Close()
+	nodeA := newServer(http.StatusOK, `{"messages":[{"timestamp":1},{"timestamp":2}],"metadata":{"totalMessages":2}}`)
+	defer nodeA.Close()
+	nodeB := newServer(http.StatusOK, `{"messages":[{"timestamp":1,"content":"forged"},{"timestamp":3}],"metadata":{"totalMessages":2}}`)
+	defer nodeB.Close()
+
+	signer := testSigner(t)
+
+	t.Run("Fails over", func(t *testing.T) {
+		c := NewLogStoreClient([]string{down.URL, nodeA.URL}, signer)
+		messages, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(messages), 2)
+	})
+
+	t.Run("Cross check agrees", func(t *testing.T) {
+		c := NewLogStoreClient([]string{nodeA.URL, down.URL, nodeA.URL}, signer, WithCrossCheck(2))
+		messages, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(messages), 2)
+	})
+
+	t.Run("Cross check disagrees", func(t *testing.T) {
+		c := NewLogStoreClient([]string{nodeA.URL, nodeB.URL}, signer, WithCrossCheck(2))
+		_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		var crossCheckErr *CrossCheckError
+		assert.Assert(t, errors.As(err, &crossCheckErr), "expected cross check error, got %v", err)
+		assert.Equal(t, len(crossCheckErr.Diffs), 1)
+		assert.DeepEqual(t, crossCheckErr.Diffs[0].Missing, []MessageRef{{Timestamp: 2}})
+		assert.DeepEqual(t, crossCheckErr.Diffs[0].Extra, []MessageRef{{Timestamp: 3}})
+		assert.DeepEqual(t, crossCheckErr.Diffs[0].Different, []MessageRef{{Timestamp: 1}})
+	})
+
+	t.Run("Cross check without enough endpoints", func(t *testing.T) {
+		c := NewLogStoreClient([]string{nodeA.URL, down.URL}, signer, WithCrossCheck(2))
+		_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.Assert(t, errors.Is(err, ErrCrossCheckFailed), "expected cross check error, got %v", err)
+	})
+}
+
+// countingSigner counts the signatures made by the signer
+type countingSigner struct {
+	auth.Signer
+	signatures atomic.Int32
+}
+
+func (s *countingSigner) Sign(msg []byte) (*auth.Signature, error) {
+	s.signatures.Add(1)
+	return s.Signer.Sign(msg)
+}
+
+func Test_AuthHeaderCache(t *testing.T) {
+	signer := testSigner(t)
+
+	t.Run("Reuses the header", func(t *testing.T) {
+		signer := &countingSigner{Signer: signer}
+		a := newAuthenticator(signer, time.Hour)
+		first, err := a.Header()
+		assert.NilError(t, err)
+		second, err := a.Header()
+		assert.NilError(t, err)
+		assert.Equal(t, first, second)
+		assert.Equal(t, signer.signatures.Load(), int32(1))
+	})
+
+	t.Run("Refreshes after the interval", func(t *testing.T) {
+		signer := &countingSigner{Signer: signer}
+		a := newAuthenticator(signer, time.Millisecond)
+		_, err := a.Header()
+		assert.NilError(t, err)
+		time.Sleep(2 * time.Millisecond)
+		_, err = a.Header()
+		assert.NilError(t, err)
+		assert.Equal(t, signer.signatures.Load(), int32(2))
+	})
+
+	t.Run("Signs again when rejected", func(t *testing.T) {
+		var headers []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = append(headers, r.Header.Get("authorization"))
+			if len(headers) == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"messages":[],"metadata":{}}`))
+		}))
+		defer server.Close()
+
+		signer := &countingSigner{Signer: signer}
+		c := NewLogStoreClient([]string{server.URL}, signer, WithAuthRefreshInterval(time.Hour))
+
+		_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(headers), 2)
+		assert.Equal(t, signer.signatures.Load(), int32(2))
+	})
+
+	t.Run("Retries build the header again", func(t *testing.T) {
+		var headers []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			headers = append(headers, r.Header.Get("authorization"))
+			if len(headers) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"messages":[],"metadata":{}}`))
+		}))
+		defer server.Close()
+
+		// the header expires between attempts, so each one must be signed again
+		signer := &countingSigner{Signer: signer}
+		c := NewLogStoreClient([]string{server.URL}, signer, WithAuthRefreshInterval(time.Nanosecond), WithRetryPolicy(RetryPolicy{
+			MaxAttempts:     3,
+			InitialInterval: time.Millisecond,
+		}))
+
+		_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.NilError(t, err)
+		assert.Equal(t, len(headers), 3)
+		assert.Equal(t, signer.signatures.Load(), int32(3))
+		for _, header := range headers {
+			assert.Assert(t, strings.HasPrefix(header, "basic "))
+		}
+	})
+}
+
+func Test_IterateAllPartitions(t *testing.T) {
+	// metadata comes first on partition 1, to make sure field order doesn't matter
+	responses := map[string]string{
+		"0": `{"messages":[{"timestamp":1,"streamPartition":0},{"timestamp":3,"streamPartition":0,"sequenceNumber":1}],"metadata":{"totalMessages":2},"extra":{"ignored":[1,2]}}`,
+		"1": `{"metadata":{"totalMessages":3},"messages":[{"timestamp":2,"streamPartition":1},{"timestamp":3,"streamPartition":1},{"timestamp":3,"streamPartition":1,"sequenceNumber":2}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partition := strings.Split(r.URL.Path, "/")[5]
+		_, _ = w.Write([]byte(responses[partition]))
+	}))
+	defer server.Close()
+
+	signer := testSigner(t)
+	c := NewLogStoreClient([]string{server.URL}, signer, WithPartitionCount(2))
+
+	it, err := c.IterateAllPartitions(context.Background(), "stream", 0, 10)
+	assert.NilError(t, err)
+	defer it.Close()
+
+	type position struct {
+		timestamp      int64
+		sequenceNumber int
+		partition      int
+	}
+	var got []position
+	for it.Next() {
+		message := it.Message()
+		got = append(got, position{message.Timestamp, message.SequenceNumber, message.StreamPartition})
+	}
+	assert.NilError(t, it.Err())
+	assert.DeepEqual(t, got, []position{{1, 0, 0}, {2, 0, 1}, {3, 0, 1}, {3, 1, 0}, {3, 2, 1}}, cmp.AllowUnexported(position{}))
+	assert.Equal(t, it.Metadata().TotalMessages, 5)
+}
+
+func Test_VerifySignature(t *testing.T) {
+	key, err := ethcrypto.HexToECDSA("0000000000000000000000000000000000000000000000000000000000000022")
+	assert.NilError(t, err)
+	publisher := strings.ToLower(ethcrypto.PubkeyToAddress(key.PublicKey).Hex())
+
+	sign := func(payload string) string {
+		signature, err := ethcrypto.Sign(accounts.TextHash([]byte(payload)), key)
+		assert.NilError(t, err)
+		signature[64] += 27
+		return hexutil.Encode(signature)
+	}
+
+	content := `{"b":1,"a":12345678901234567890}`
+	payload := "stream" + "0" + "1000" + "2" + publisher + "chain" + "9991" + content
+	encode := func(content, signature string) string {
+		return `{"streamId":"stream","streamPartition":0,"timestamp":1000,"sequenceNumber":2,"publisherId":"` + publisher +
+			`","msgChainId":"chain","prevMsgRef":{"timestamp":999,"sequenceNumber":1},"content":` + content +
+			`,"signatureType":2,"signature":"` + signature + `"}`
+	}
+
+	tests := []struct {
+		name    string
+		message string
+		wantErr error
+	}{
+		{name: "Valid", message: encode(content, sign(payload))},
+		{name: "Tampered content", message: encode(`{"b":2,"a":12345678901234567890}`, sign(payload)), wantErr: ErrInvalidSignature},
+		{name: "Other signer", message: encode(content, sign(payload+"x")), wantErr: ErrInvalidSignature},
+		{name: "Unsigned", message: `{"signatureType":0}`, wantErr: ErrUnsignedMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message JSONStreamMessage
+			assert.NilError(t, json.Unmarshal([]byte(tt.message), &message))
+			err := VerifySignature(&message)
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			assert.NilError(t, err)
+		})
+	}
+}
+
+func Test_DecryptContent(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	encrypt := func(plaintext string) string {
+		block, err := aes.NewCipher(key)
+		assert.NilError(t, err)
+		ciphertext := make([]byte, aes.BlockSize+len(plaintext))
+		cipher.NewCTR(block, ciphertext[:aes.BlockSize]).XORKeyStream(ciphertext[aes.BlockSize:], []byte(plaintext))
+		return hex.EncodeToString(ciphertext)
+	}
+	encode := func(groupKeyId, content string) string {
+		return `{"encryptionType":2,"groupKeyId":"` + groupKeyId + `","content":"` + content + `"}`
+	}
+
+	tests := []struct {
+		name        string
+		message     string
+		wantContent string
+		wantErr     error
+	}{
+		{name: "Valid", message: encode("key1", encrypt(`{"a":1}`)), wantContent: `{"a":1}`},
+		{name: "Unknown key", message: encode("key2", encrypt(`{"a":1}`)), wantErr: ErrMissingGroupKey},
+		{name: "Not JSON", message: encode("key1", encrypt(`{"a":`)), wantErr: ErrDecryptionFailed},
+		{name: "RSA", message: `{"encryptionType":1,"groupKeyId":"key1","content":"00"}`, wantErr: ErrUnsupportedEncryption},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message JSONStreamMessage
+			assert.NilError(t, json.Unmarshal([]byte(tt.message), &message))
+			assert.Assert(t, message.IsEncrypted())
+			err := DecryptContent(&message, GroupKeys{"key1": key})
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Assert(t, !message.IsEncrypted())
+			content, err := json.Marshal(message.Content)
+			assert.NilError(t, err)
+			assert.Equal(t, string(content), tt.wantContent)
+		})
+	}
+}
+
+func Test_Payload(t *testing.T) {
+	tests := []struct {
+		name        string
+		message     string
+		wantPayload string
+		wantErr     error
+	}{
+		{name: "JSON", message: `{"contentType":0,"content":{"b":1,"a":12345678901234567890}}`, wantPayload: `{"b":1,"a":12345678901234567890}`},
+		{name: "Binary", message: `{"contentType":1,"content":"00ff10"}`, wantPayload: "\x00\xff\x10"},
+		{name: "Text", message: `{"contentType":2,"content":"hello \"world\""}`, wantPayload: `hello "world"`},
+		{name: "Encrypted", message: `{"contentType":0,"encryptionType":2,"content":"abcd"}`, wantPayload: "abcd"},
+		{name: "Unknown", message: `{"contentType":9,"content":"abcd"}`, wantErr: ErrUnsupportedContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var message JSONStreamMessage
+			assert.NilError(t, json.Unmarshal([]byte(tt.message), &message))
+			payload, err := message.Payload()
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, string(payload), tt.wantPayload)
+		})
+	}
+}
+
+func Test_CanonicalizeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "Sorted keys", content: `{ "b": 1, "a": {"d": [1, 2], "c": null} }`, want: `{"a":{"c":null,"d":[1,2]},"b":1}`},
+		{name: "Large numbers", content: `{"a": 12345678901234567890123, "b": 0.10000000000000000001, "c": 1e400}`, want: `{"a":12345678901234567890123,"b":0.10000000000000000001,"c":1e400}`},
+		{name: "Strings", content: `"<a & b>"`, want: `"<a & b>"`},
+		{name: "Invalid", content: `{"a":`, wantErr: true},
+		{name: "Trailing data", content: `{} {}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonical, err := CanonicalizeJSON(json.RawMessage(tt.content))
 			if tt.wantErr {
-				assert.Error(t, err, "expected error but got nil")
+				assert.Assert(t, err != nil)
 				return
 			}
 			assert.NilError(t, err)
-			assert.Assert(t, got > 0)
+			assert.Equal(t, string(canonical), tt.want)
 		})
 	}
 }
+
+func Test_IterateRangeBetweenCursors(t *testing.T) {
+	// the node ignores sequence numbers, so the client must drop the messages outside the cursors
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		_, _ = w.Write([]byte(`{"messages":[{"timestamp":2,"sequenceNumber":0},{"timestamp":2,"sequenceNumber":1},{"timestamp":2,"sequenceNumber":2},{"timestamp":3,"sequenceNumber":0},{"timestamp":3,"sequenceNumber":1}],"metadata":{"hasNext":false,"totalMessages":5}}`))
+	}))
+	defer server.Close()
+
+	signer := testSigner(t)
+	c := NewLogStoreClient([]string{server.URL}, signer)
+
+	messages, metadata, err := drain(c.IterateRangeBetween(context.Background(), "stream", Cursor{Timestamp: 2, SequenceNumber: 1}, Cursor{Timestamp: 3}, 0))
+	assert.NilError(t, err)
+	assert.Equal(t, query.Get("fromTimestamp"), "2")
+	assert.Equal(t, query.Get("fromSequenceNumber"), "1")
+	assert.Equal(t, query.Get("toTimestamp"), "3")
+	assert.Equal(t, query.Get("toSequenceNumber"), "0")
+	assert.Equal(t, metadata.TotalMessages, 5)
+	assert.Equal(t, metadata.OutOfRange, 2)
+	assert.Equal(t, len(messages), 3)
+	for i, want := range []MessageRef{{Timestamp: 2, SequenceNumber: 1}, {Timestamp: 2, SequenceNumber: 2}, {Timestamp: 3}} {
+		assert.Equal(t, messages[i].Ref(), want)
+	}
+
+	// plain timestamp ranges don't send sequence numbers
+	_, _, err = c.QueryRange(context.Background(), "stream", 2, 3, 0)
+	assert.NilError(t, err)
+	assert.Assert(t, !query.Has("fromSequenceNumber") && !query.Has("toSequenceNumber"))
+}
+
+func Test_Signers(t *testing.T) {
+	privateKeyHex := "0000000000000000000000000000000000000000000000000000000000000022"
+	key, err := ethcrypto.HexToECDSA(privateKeyHex)
+	assert.NilError(t, err)
+	address := ethcrypto.PubkeyToAddress(key.PublicKey).Bytes()
+	dir := t.TempDir()
+
+	t.Run("Private key", func(t *testing.T) {
+		signer, err := NewPrivateKeySigner("0x" + privateKeyHex)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, signer.Identity(), address)
+	})
+
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv("TEST_LOGSTORE_KEY", privateKeyHex)
+		signer, err := NewEnvSigner("TEST_LOGSTORE_KEY")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, signer.Identity(), address)
+
+		_, err = NewEnvSigner("TEST_LOGSTORE_MISSING_KEY")
+		assert.Assert(t, err != nil)
+	})
+
+	t.Run("Keystore", func(t *testing.T) {
+		keyJSON, err := keystore.EncryptKey(&keystore.Key{Id: uuid.New(), Address: ethcrypto.PubkeyToAddress(key.PublicKey), PrivateKey: key}, "secret", keystore.LightScryptN, keystore.LightScryptP)
+		assert.NilError(t, err)
+		keystoreFile := filepath.Join(dir, "keystore.json")
+		passwordFile := filepath.Join(dir, "password.txt")
+		assert.NilError(t, os.WriteFile(keystoreFile, keyJSON, 0600))
+		assert.NilError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+		signer, err := NewKeystoreSigner(keystoreFile, passwordFile)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, signer.Identity(), address)
+
+		assert.NilError(t, os.WriteFile(passwordFile, []byte("wrong"), 0600))
+		_, err = NewKeystoreSigner(keystoreFile, passwordFile)
+		assert.Assert(t, err != nil)
+	})
+
+	t.Run("Node key", func(t *testing.T) {
+		nodeKey, err := crypto.GenerateEd25519Key()
+		assert.NilError(t, err)
+		nodeKeyFile := filepath.Join(dir, "private_key")
+		assert.NilError(t, os.WriteFile(nodeKeyFile, []byte(nodeKey.Hex()), 0600))
+
+		signer, err := NewNodeKeySigner(nodeKeyFile)
+		assert.NilError(t, err)
+		again, err := NewNodeKeySigner(nodeKeyFile)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, signer.Identity(), again.Identity())
+		assert.Equal(t, len(signer.Identity()), 20)
+
+		// the documented derivation, so operators can compute the address offline
+		derived := ethcrypto.Keccak256(append([]byte("logstore-oracle:"), nodeKey.Bytes()[:32]...))
+		derivedKey, err := crypto.Secp256k1PrivateKeyFromHex(hex.EncodeToString(derived))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, signer.Identity(), (&auth.EthPersonalSigner{Key: *derivedKey}).Identity())
+	})
+}
+
+func Test_RateLimit(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			observed := maxInFlight.Load()
+			if current <= observed || maxInFlight.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"messages":[],"metadata":{"hasNext":false,"totalMessages":0}}`))
+	}))
+	defer server.Close()
+
+	signer := testSigner(t)
+
+	t.Run("Max concurrency", func(t *testing.T) {
+		c := NewLogStoreClient([]string{server.URL}, signer, WithRateLimit(RateLimit{MaxConcurrency: 2}))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+				assert.Check(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Assert(t, maxInFlight.Load() <= 2, "%d requests in flight", maxInFlight.Load())
+	})
+
+	t.Run("Slots are held until the body is closed", func(t *testing.T) {
+		c := NewLogStoreClient([]string{server.URL}, signer, WithRateLimit(RateLimit{MaxConcurrency: 1}))
+
+		body, err := c.openStream(context.Background(), nil, "/stores/stream/data/partitions/0/range", nil, false)
+		assert.NilError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = c.openStream(ctx, nil, "/stores/stream/data/partitions/0/range", nil, false)
+		assert.Assert(t, err != nil)
+
+		assert.NilError(t, body.Close())
+		// closing twice doesn't release another request's slot
+		assert.NilError(t, body.Close())
+		body, err = c.openStream(context.Background(), nil, "/stores/stream/data/partitions/0/range", nil, false)
+		assert.NilError(t, err)
+		assert.NilError(t, body.Close())
+		assert.Equal(t, len(c.limiter.slots), 0)
+	})
+
+	t.Run("Partitions must fit in the slots", func(t *testing.T) {
+		c := NewLogStoreClient([]string{server.URL}, signer, WithRateLimit(RateLimit{MaxConcurrency: 1}), WithPartitionCount(2))
+
+		_, err := c.IterateAllPartitions(context.Background(), "stream", 0, 10)
+		assert.ErrorContains(t, err, "lower than the 2 partitions")
+	})
+
+	t.Run("Waits respect the context", func(t *testing.T) {
+		c := NewLogStoreClient([]string{server.URL}, signer, WithRateLimit(RateLimit{RequestsPerSecond: 0.1}))
+
+		_, _, err := c.QueryRange(context.Background(), "stream", 0, 10, 0)
+		assert.NilError(t, err)
+
+		// the next token comes in 10 seconds
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err = c.QueryRange(ctx, "stream", 0, 10, 0)
+		assert.Assert(t, err != nil)
+		assert.Assert(t, time.Since(start) < time.Second)
+	})
+}
+
+func Test_Subscribe(t *testing.T) {
+	const streamId = "0xabc/stream"
+	server := fake_logstore.NewServer()
+	defer server.Close()
+	server.RequireApiKey("key")
+
+	signer := testSigner(t)
+
+	c := NewLogStoreClient([]string{server.URL}, signer)
+	_, err := c.Subscribe(context.Background(), streamId, 1)
+	assert.Assert(t, errors.Is(err, ErrSubscriptionNotConfigured))
+
+	c = NewLogStoreClient([]string{server.URL}, signer, WithSubscriptionEndpoint(server.URL, "wrong"))
+	_, err = c.Subscribe(context.Background(), streamId, 1)
+	assert.Assert(t, errors.Is(err, ErrUnauthorized), "%v", err)
+
+	c = NewLogStoreClient([]string{server.URL}, signer, WithSubscriptionEndpoint(server.URL, "key"))
+	subscription, err := c.Subscribe(context.Background(), streamId, 1)
+	assert.NilError(t, err)
+	defer subscription.Close()
+	assert.Equal(t, server.Subscribers(streamId, 1), 1)
+
+	server.Publish(
+		fake_logstore.Message{StreamId: streamId, StreamPartition: 1, Timestamp: 1, PublisherId: "0xdef", MsgChainId: "chain", Content: json.RawMessage(`{"a":1}`)},
+		fake_logstore.Message{StreamId: streamId, StreamPartition: 1, Timestamp: 1, SequenceNumber: 1, PublisherId: "0xdef", MsgChainId: "chain"},
+	)
+
+	message, err := subscription.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, message.Ref(), MessageRef{Timestamp: 1, PublisherId: "0xdef", MsgChainId: "chain"})
+	assert.Equal(t, message.StreamId, streamId)
+	assert.Equal(t, message.StreamPartition, 1)
+	assert.Equal(t, string(message.Content), `{"a":1}`)
+
+	message, err = subscription.Next()
+	assert.NilError(t, err)
+	assert.Equal(t, message.Ref(), MessageRef{Timestamp: 1, SequenceNumber: 1, PublisherId: "0xdef", MsgChainId: "chain"})
+
+	// the node closed the connection
+	server.DropSubscriptions()
+	_, err = subscription.Next()
+	assert.Assert(t, err != nil)
+}
+
+func Test_PartitionCountFallback(t *testing.T) {
+	signer := testSigner(t)
+
+	// without discovery, streams have a single partition unless configured
+	c := NewLogStoreClient([]string{"http://localhost"}, signer)
+	count, err := c.GetStreamPartitionCount(context.Background(), "stream")
+	assert.NilError(t, err)
+	assert.Equal(t, count, 1)
+
+	// nothing listens on the registry rpc, so the configured count is used
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	registry, err := NewStreamRegistry(server.URL, DefaultStreamRegistryAddress)
+	assert.NilError(t, err)
+
+	c = NewLogStoreClient([]string{"http://localhost"}, signer, WithStreamRegistry(registry), WithPartitionCount(3))
+	_, err = c.DiscoverStreamPartitionCount(context.Background(), "stream")
+	assert.Assert(t, err != nil)
+	count, err = c.GetStreamPartitionCount(context.Background(), "stream")
+	assert.NilError(t, err)
+	assert.Equal(t, count, 3)
+
+	_, err = NewStreamRegistry("", DefaultStreamRegistryAddress)
+	assert.ErrorContains(t, err, "missing stream registry rpc url")
+}
+
+// writePEM writes a PEM block to a file of the directory, returning its path
+func writePEM(t *testing.T, dir, name, blockType string, bytes []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.NilError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0600))
+	return path
+}
+
+func Test_NewHTTPClient(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Trusts a custom CA", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer server.Close()
+
+		// the server certificate is self-signed, so it's its own CA
+		caFile := writePEM(t, dir, "server-ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+		client, err := NewHTTPClient(TransportOptions{})
+		assert.NilError(t, err)
+		_, err = client.Get(server.URL)
+		var unknownAuthority x509.UnknownAuthorityError
+		assert.Assert(t, errors.As(err, &unknownAuthority), "expected unknown authority, got %v", err)
+
+		client, err = NewHTTPClient(TransportOptions{CAFile: caFile})
+		assert.NilError(t, err)
+		resp, err := client.Get(server.URL)
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+	})
+
+	t.Run("Sends the client certificate", func(t *testing.T) {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NilError(t, err)
+		caTemplate := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "client ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+		}
+		caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+		assert.NilError(t, err)
+		caCert, err := x509.ParseCertificate(caDER)
+		assert.NilError(t, err)
+
+		clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NilError(t, err)
+		clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "oracle"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, &clientKey.PublicKey, caKey)
+		assert.NilError(t, err)
+		clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+		assert.NilError(t, err)
+		certFile := writePEM(t, dir, "client-cert.pem", "CERTIFICATE", clientDER)
+		keyFile := writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", clientKeyDER)
+
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(caCert)
+		var clientNames []string
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientNames = append(clientNames, r.TLS.PeerCertificates[0].Subject.CommonName)
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		server.StartTLS()
+		defer server.Close()
+		caFile := writePEM(t, dir, "mtls-server-ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+		client, err := NewHTTPClient(TransportOptions{CAFile: caFile})
+		assert.NilError(t, err)
+		_, err = client.Get(server.URL)
+		assert.Assert(t, err != nil, "expected the server to require a client certificate")
+
+		client, err = NewHTTPClient(TransportOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+		assert.NilError(t, err)
+		resp, err := client.Get(server.URL)
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.DeepEqual(t, clientNames, []string{"oracle"})
+	})
+
+	t.Run("Requires both certificate and key", func(t *testing.T) {
+		_, err := NewHTTPClient(TransportOptions{CertFile: filepath.Join(dir, "client-cert.pem")})
+		assert.ErrorContains(t, err, "both client certificate and key are required")
+	})
+}
@@ -0,0 +1,99 @@
+package logstore_client
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"io"
+	"sync"
+)
+
+// RateLimit configures how fast the client sends requests, so shared log store nodes don't throttle us while catching up.
+// Retries are limited too. Zero values disable each limit.
+type RateLimit struct {
+	// RequestsPerSecond is the rate the token bucket is refilled at
+	RequestsPerSecond float64
+	// Burst is the size of the token bucket. It defaults to 1
+	Burst int
+	// MaxConcurrency is the maximum of requests open at once. A request holds its slot until its response body is closed,
+	// so streamed responses count until they are drained. Every partition of a window is streamed at once,
+	// so it can't be lower than the partition count of the stream.
+	MaxConcurrency int
+}
+
+// WithRateLimit limits the requests sent by the client, to all endpoints together
+func WithRateLimit(limit RateLimit) ClientOption {
+	return func(c *LogStoreClient) {
+		c.limiter = newRequestLimiter(limit)
+	}
+}
+
+// requestLimiter combines a token bucket with a semaphore. Its zero value doesn't limit anything
+type requestLimiter struct {
+	bucket *rate.Limiter
+	slots  chan struct{}
+}
+
+func newRequestLimiter(limit RateLimit) *requestLimiter {
+	l := &requestLimiter{}
+
+	if limit.RequestsPerSecond > 0 {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), burst)
+	}
+
+	if limit.MaxConcurrency > 0 {
+		l.slots = make(chan struct{}, limit.MaxConcurrency)
+	}
+
+	return l
+}
+
+// maxConcurrency is the number of requests that can be open at once, or 0 if it's unlimited
+func (l *requestLimiter) maxConcurrency() int {
+	if l == nil || l.slots == nil {
+		return 0
+	}
+	return cap(l.slots)
+}
+
+// acquire waits until a request can be sent, returning the function that releases its slot.
+// It gives up when the context is done.
+func (l *requestLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if l.bucket != nil {
+		err := l.bucket.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// limitedBody is a response body that releases the slot of its request once it's closed
+type limitedBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,167 @@
+package logstore_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff"
+)
+
+// RetryPolicy configures how the client retries failed requests.
+// Only idempotent requests are retried, and only for errors that may go away by themselves,
+// such as rate limiting, server errors and network failures.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, so 1 or less disables retries
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+// WithRetryPolicy enables retries on the client
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *LogStoreClient) {
+		c.retryPolicy = policy
+	}
+}
+
+func (p RetryPolicy) newBackOff() *backoff.ExponentialBackOff {
+	expBackoff := backoff.NewExponentialBackOff()
+	if p.InitialInterval > 0 {
+		expBackoff.InitialInterval = p.InitialInterval
+	}
+	if p.MaxInterval > 0 {
+		expBackoff.MaxInterval = p.MaxInterval
+	}
+	// attempts are limited by MaxAttempts instead
+	expBackoff.MaxElapsedTime = 0
+	expBackoff.Reset()
+	return expBackoff
+}
+
+// isRetryableRequest tells if a request can be safely sent again
+func isRetryableRequest(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// isRetryableError tells if an error may go away by retrying the same request
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServerError) {
+		return true
+	}
+
+	// connection dropped while reading the body
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryAfter gets the wait requested by the node, if any
+func retryAfter(err error) time.Duration {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
+
+// doWithRetry sends the request and reads the body, retrying it according to the retry policy
+func (c *LogStoreClient) doWithRetry(newRequest requestBuilder) ([]byte, error) {
+	var body []byte
+	err := c.retry(newRequest, func(req *http.Request) error {
+		var err error
+		body, err = c.do(req)
+		return err
+	})
+	return body, err
+}
+
+// openWithRetry sends the request, retrying it according to the retry policy until we get a successful response.
+// The body is returned open, to be read as a stream. Failures while reading it are up to the caller.
+func (c *LogStoreClient) openWithRetry(newRequest requestBuilder) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := c.retry(newRequest, func(req *http.Request) error {
+		var err error
+		body, err = c.open(req)
+		return err
+	})
+	return body, err
+}
+
+// retry runs the operation until it succeeds, fails with a non retryable error, or the attempts are over.
+// Each attempt sends a new request, as the authorization header may have been refreshed meanwhile.
+func (c *LogStoreClient) retry(newRequest requestBuilder, operation func(req *http.Request) error) error {
+	expBackoff := c.retryPolicy.newBackOff()
+
+	for attempt := 1; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return err
+		}
+
+		err = operation(req)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= c.retryPolicy.MaxAttempts || !isRetryableRequest(req) || !isRetryableError(err) {
+			return err
+		}
+
+		wait := expBackoff.NextBackOff()
+		if requested := retryAfter(err); requested > wait {
+			wait = requested
+		}
+
+		select {
+		case <-req.Context().Done():
+			return errors.Join(err, req.Context().Err())
+		case <-time.After(wait):
+		}
+	}
+}
+
+// do sends the request and reads the body of a successful response
+func (c *LogStoreClient) do(req *http.Request) ([]byte, error) {
+	body, err := c.open(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// open sends the request and returns the body of a successful response.
+// The request keeps its concurrency slot until the body is closed.
+func (c *LogStoreClient) open(req *http.Request) (io.ReadCloser, error) {
+	release, err := c.limiter.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	err = checkResponse(resp)
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+
+	return &limitedBody{ReadCloser: resp.Body, release: release}, nil
+}
@@ -0,0 +1,126 @@
+package logstore_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Streamr signature types
+const (
+	SignatureTypeNone      = 0
+	SignatureTypeEthLegacy = 1
+	SignatureTypeEth       = 2
+)
+
+var (
+	ErrUnsignedMessage      = errors.New("message is not signed")
+	ErrUnsupportedSignature = errors.New("unsupported signature type")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// VerifySignature checks that the message was signed by its publisher.
+// Signatures are Ethereum personal signatures over the payload defined by the Streamr protocol,
+// so a log store node can't forge or change messages without being noticed.
+func VerifySignature(message *JSONStreamMessage) error {
+	payload, err := signaturePayload(message)
+	if err != nil {
+		return err
+	}
+
+	signature, err := hexutil.Decode(message.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if len(signature) != crypto.SignatureLength {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidSignature, crypto.SignatureLength, len(signature))
+	}
+
+	// wallets produce v as 27 or 28, while go-ethereum expects 0 or 1
+	signature = bytes.Clone(signature)
+	if signature[crypto.RecoveryIDOffset] >= 27 {
+		signature[crypto.RecoveryIDOffset] -= 27
+	}
+
+	publicKey, err := crypto.SigToPub(accounts.TextHash(payload), signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+
+	if !common.IsHexAddress(message.PublisherId) {
+		return fmt.Errorf("%w: publisher %s is not an address", ErrInvalidSignature, message.PublisherId)
+	}
+
+	signer := crypto.PubkeyToAddress(*publicKey)
+	if signer != common.HexToAddress(message.PublisherId) {
+		return fmt.Errorf("%w: signed by %s, but published by %s", ErrInvalidSignature, signer.Hex(), message.PublisherId)
+	}
+
+	return nil
+}
+
+// signaturePayload builds the payload signed by the publisher, according to the signature type
+func signaturePayload(message *JSONStreamMessage) ([]byte, error) {
+	content, err := serializedContent(message.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	var payload strings.Builder
+	switch message.SignatureType {
+	case SignatureTypeNone:
+		return nil, ErrUnsignedMessage
+	case SignatureTypeEthLegacy:
+		payload.WriteString(message.StreamId)
+		payload.WriteString(strconv.Itoa(message.StreamPartition))
+		payload.WriteString(strconv.FormatInt(message.Timestamp, 10))
+		payload.WriteString(strings.ToLower(message.PublisherId))
+		payload.WriteString(content)
+	case SignatureTypeEth:
+		payload.WriteString(message.StreamId)
+		payload.WriteString(strconv.Itoa(message.StreamPartition))
+		payload.WriteString(strconv.FormatInt(message.Timestamp, 10))
+		payload.WriteString(strconv.Itoa(message.SequenceNumber))
+		payload.WriteString(strings.ToLower(message.PublisherId))
+		payload.WriteString(message.MsgChainId)
+		if message.PrevMsgRef != nil {
+			payload.WriteString(strconv.FormatInt(message.PrevMsgRef.Timestamp, 10))
+			payload.WriteString(strconv.Itoa(message.PrevMsgRef.SequenceNumber))
+		}
+		payload.WriteString(content)
+		if len(message.NewGroupKey) > 0 && string(message.NewGroupKey) != "null" {
+			newGroupKey, err := serializedContent(message.NewGroupKey)
+			if err != nil {
+				return nil, err
+			}
+			payload.WriteString(newGroupKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSignature, message.SignatureType)
+	}
+
+	return []byte(payload.String()), nil
+}
+
+// serializedContent gets the content as the publisher serialized it.
+// Encrypted or string contents are sent as JSON strings, and were signed without the quotes.
+func serializedContent(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || raw[0] != '"' {
+		return string(raw), nil
+	}
+
+	var content string
+	err := json.Unmarshal(raw, &content)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode content: %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,101 @@
+package logstore_client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/kwilteam/kwil-db/core/crypto"
+	"github.com/kwilteam/kwil-db/core/crypto/auth"
+	"os"
+	"strings"
+)
+
+// Log store nodes authenticate Ethereum personal signatures, so every signer here is an [auth.EthPersonalSigner].
+// They only differ in where the key comes from.
+
+// DefaultPrivateKeyEnv is the environment variable read by [NewEnvSigner] if no other is given
+const DefaultPrivateKeyEnv = "LOGSTORE_ORACLE_PRIVATE_KEY"
+
+// nodeKeyDerivationPrefix separates the keys derived from kwild node keys from any other use of them
+const nodeKeyDerivationPrefix = "logstore-oracle:"
+
+// NewPrivateKeySigner creates a signer from a hex encoded secp256k1 private key
+func NewPrivateKeySigner(privateKeyHex string) (auth.Signer, error) {
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex(strings.TrimPrefix(strings.TrimSpace(privateKeyHex), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return &auth.EthPersonalSigner{Key: *privateKey}, nil
+}
+
+// NewEnvSigner creates a signer from a hex encoded private key in an environment variable
+func NewEnvSigner(name string) (auth.Signer, error) {
+	if name == "" {
+		name = DefaultPrivateKeyEnv
+	}
+
+	privateKeyHex, ok := os.LookupEnv(name)
+	if !ok || privateKeyHex == "" {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return NewPrivateKeySigner(privateKeyHex)
+}
+
+// NewKeystoreSigner creates a signer from an encrypted geth keystore file, decrypted with the password in passwordFile.
+// Trailing newlines of the password file are ignored.
+func NewKeystoreSigner(keystoreFile, passwordFile string) (auth.Signer, error) {
+	keyJSON, err := os.ReadFile(keystoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore: %w", err)
+	}
+
+	password, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keystore password: %w", err)
+	}
+
+	key, err := keystore.DecryptKey(keyJSON, strings.TrimRight(string(password), "\r\n"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore: %w", err)
+	}
+
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex(hex.EncodeToString(ethcrypto.FromECDSA(key.PrivateKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore private key: %w", err)
+	}
+	return &auth.EthPersonalSigner{Key: *privateKey}, nil
+}
+
+// NewNodeKeySigner creates a signer from the private key file of the kwild node, so no other key has to be managed.
+// kwild node keys are ed25519, and log store nodes only accept secp256k1 Ethereum signatures, so the node key can't
+// sign by itself. The secp256k1 private key is keccak256("logstore-oracle:" || seed), where seed is the first 32 bytes
+// of the ed25519 private key. It's the same on every start, and can be derived offline from the node key.
+// The derived address, not the node identity, is what must be allowed on the log store node.
+func NewNodeKeySigner(nodeKeyFile string) (auth.Signer, error) {
+	nodeKeyHex, err := os.ReadFile(nodeKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+
+	nodeKeyBytes, err := hex.DecodeString(string(bytes.TrimSpace(nodeKeyHex)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode node key: %w", err)
+	}
+
+	nodeKey, err := crypto.Ed25519PrivateKeyFromBytes(nodeKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node key: %w", err)
+	}
+
+	// the first 32 bytes of an ed25519 private key are its seed
+	seed := nodeKey.Bytes()[:32]
+	derived := ethcrypto.Keccak256(append([]byte(nodeKeyDerivationPrefix), seed...))
+
+	privateKey, err := crypto.Secp256k1PrivateKeyFromHex(hex.EncodeToString(derived))
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive private key from node key: %w", err)
+	}
+	return &auth.EthPersonalSigner{Key: *privateKey}, nil
+}
@@ -0,0 +1,91 @@
+package logstore_client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// DefaultStreamRegistryAddress is the address of the Streamr StreamRegistry contract on Polygon.
+// There's no default RPC, it must be configured by the operator.
+const DefaultStreamRegistryAddress = "0x0D483E10612F327FC11965Fc82E90dC19b141641"
+
+const streamRegistryABI = `[{"inputs":[{"internalType":"string","name":"streamId","type":"string"}],"name":"getStreamMetadata","outputs":[{"internalType":"string","name":"","type":"string"}],"stateMutability":"view","type":"function"}]`
+
+// StreamRegistry reads stream metadata, such as the partition count, from the Streamr StreamRegistry contract.
+// Streams are registered on-chain, so this is the source of truth that every validator agrees on.
+type StreamRegistry struct {
+	rpcUrl  string
+	address common.Address
+	abi     abi.ABI
+}
+
+func NewStreamRegistry(rpcUrl, address string) (*StreamRegistry, error) {
+	if rpcUrl == "" {
+		return nil, fmt.Errorf("missing stream registry rpc url")
+	}
+	if !common.IsHexAddress(address) {
+		return nil, fmt.Errorf("invalid stream registry address: %s", address)
+	}
+
+	parsedABI, err := abi.JSON(strings.NewReader(streamRegistryABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stream registry abi: %w", err)
+	}
+
+	return &StreamRegistry{
+		rpcUrl:  rpcUrl,
+		address: common.HexToAddress(address),
+		abi:     parsedABI,
+	}, nil
+}
+
+// JSONStreamMetadata is the metadata stored for a stream in the registry.
+// Streams registered without partitions in their metadata have a single partition.
+type JSONStreamMetadata struct {
+	Partitions int `json:"partitions"`
+}
+
+func (r *StreamRegistry) GetStreamMetadata(ctx context.Context, streamId string) (*JSONStreamMetadata, error) {
+	client, err := ethclient.DialContext(ctx, r.rpcUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", r.rpcUrl, err)
+	}
+	defer client.Close()
+
+	callData, err := r.abi.Pack("getStreamMetadata", streamId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack call: %w", err)
+	}
+
+	result, err := client.CallContract(ctx, ethereum.CallMsg{To: &r.address, Data: callData}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of stream %s: %w", streamId, err)
+	}
+
+	var rawMetadata string
+	err = r.abi.UnpackIntoInterface(&rawMetadata, "getStreamMetadata", result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack metadata of stream %s: %w", streamId, err)
+	}
+
+	metadata := JSONStreamMetadata{Partitions: 1}
+	if rawMetadata != "" {
+		err = json.Unmarshal([]byte(rawMetadata), &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode metadata of stream %s: %w", streamId, err)
+		}
+	}
+
+	if metadata.Partitions < 1 {
+		metadata.Partitions = 1
+	}
+
+	return &metadata, nil
+}
@@ -0,0 +1,137 @@
+package logstore_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+ * The Log Store node has no documented route to receive messages live, so subscriptions go to the websocket plugin
+ * of a Streamr node, as documented with the Streamr node interface. The Log Store node is a Streamr node, so its own
+ * plugin can be enabled, or any other node subscribed to the stream can be used.
+ *
+ * Path: /streams/:streamId/subscribe?partitions=:partition&apiKey=:apiKey
+ * Upgrades to a WebSocket, where the node sends each message of the partition as it's received, as a JSON text frame.
+ * The plugin must have `payloadMetadata` enabled, so frames are `{"content": ..., "metadata": {...}}`, with the
+ * timestamp, sequence number, publisher and message chain of the message in the metadata.
+ */
+
+// ErrSubscriptionNotConfigured is returned when subscribing without a subscription endpoint
+var ErrSubscriptionNotConfigured = errors.New("no subscription endpoint configured")
+
+// WithSubscriptionEndpoint sets the websocket plugin endpoint of the Streamr node messages are received live from,
+// and its API key, if it requires one
+func WithSubscriptionEndpoint(endpoint, apiKey string) ClientOption {
+	return func(c *LogStoreClient) {
+		c.subscriptionEndpoint = endpoint
+		c.subscriptionApiKey = apiKey
+	}
+}
+
+// Subscription receives the messages of a partition live, as the Streamr node gets them.
+// Messages published while it's disconnected are lost, so it never replaces range queries.
+type Subscription struct {
+	conn      *websocket.Conn
+	streamId  string
+	partition int
+	// Endpoint is the endpoint the subscription is connected to
+	Endpoint string
+}
+
+// subscriptionFrame is a message sent by the websocket plugin, with its metadata
+type subscriptionFrame struct {
+	Content  json.RawMessage `json:"content"`
+	Metadata struct {
+		Timestamp      int64  `json:"timestamp"`
+		SequenceNumber int    `json:"sequenceNumber"`
+		PublisherId    string `json:"publisherId"`
+		MsgChainId     string `json:"msgChainId"`
+	} `json:"metadata"`
+}
+
+// Subscribe opens a subscription to a partition, on the subscription endpoint.
+// Subscriptions are long-lived, so they don't take a concurrency slot of the rate limit.
+func (c *LogStoreClient) Subscribe(ctx context.Context, streamId string, partition int) (*Subscription, error) {
+	if c.subscriptionEndpoint == "" {
+		return nil, ErrSubscriptionNotConfigured
+	}
+
+	q := url.Values{}
+	q.Add("partitions", strconv.Itoa(partition))
+	if c.subscriptionApiKey != "" {
+		q.Add("apiKey", c.subscriptionApiKey)
+	}
+	subscriptionURL := websocketURL(c.subscriptionEndpoint) + "/streams/" + url.PathEscape(streamId) + "/subscribe?" + q.Encode()
+
+	dialer := &websocket.Dialer{Proxy: http.ProxyFromEnvironment}
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		dialer.Proxy = transport.Proxy
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	conn, resp, err := dialer.DialContext(ctx, subscriptionURL, nil)
+	if err != nil {
+		// a rejected upgrade has the same meaning as a rejected request
+		if resp != nil {
+			if statusErr := checkResponse(resp); statusErr != nil {
+				err = statusErr
+			}
+			resp.Body.Close()
+		}
+		return nil, fmt.Errorf("%s: %w", c.subscriptionEndpoint, err)
+	}
+
+	return &Subscription{conn: conn, streamId: streamId, partition: partition, Endpoint: c.subscriptionEndpoint}, nil
+}
+
+// Next blocks until the next message arrives. Any error ends the subscription.
+// Only the reference fields and the content of the message are set, the plugin doesn't send the others.
+func (s *Subscription) Next() (JSONStreamMessage, error) {
+	_, data, err := s.conn.ReadMessage()
+	if err != nil {
+		return JSONStreamMessage{}, err
+	}
+
+	var frame subscriptionFrame
+	err = json.Unmarshal(data, &frame)
+	if err != nil {
+		return JSONStreamMessage{}, malformedResponseError(err)
+	}
+	if frame.Metadata.PublisherId == "" {
+		return JSONStreamMessage{}, malformedResponseError(errors.New("message without metadata, payloadMetadata must be enabled on the websocket plugin"))
+	}
+
+	return JSONStreamMessage{
+		StreamId:        s.streamId,
+		StreamPartition: s.partition,
+		Timestamp:       frame.Metadata.Timestamp,
+		SequenceNumber:  frame.Metadata.SequenceNumber,
+		PublisherId:     frame.Metadata.PublisherId,
+		MsgChainId:      frame.Metadata.MsgChainId,
+		Content:         frame.Content,
+	}, nil
+}
+
+// Close closes the subscription, making a blocked Next return
+func (s *Subscription) Close() error {
+	return s.conn.Close()
+}
+
+// websocketURL converts an http(s) endpoint into its ws(s) equivalent
+func websocketURL(endpoint string) string {
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		return "wss://" + strings.TrimPrefix(endpoint, "https://")
+	case strings.HasPrefix(endpoint, "http://"):
+		return "ws://" + strings.TrimPrefix(endpoint, "http://")
+	default:
+		return endpoint
+	}
+}
@@ -0,0 +1,76 @@
+package logstore_client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportOptions configures the HTTP client used to talk to the log store node
+type TransportOptions struct {
+	// Timeout is the limit for a whole request, including reading the body. Zero means no timeout.
+	// Keep in mind the readiness check may take up to 30 seconds on the node side.
+	Timeout time.Duration
+	// CAFile is a PEM bundle of certificate authorities to trust, besides the system ones
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key, used for mutual TLS
+	CertFile string
+	KeyFile  string
+	// ProxyURL is the proxy used for every request. If empty, proxy environment variables are used.
+	ProxyURL string
+}
+
+// NewHTTPClient creates an HTTP client from the transport options.
+// The client should be shared between requests, so connections are reused.
+func NewHTTPClient(options TransportOptions) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if options.CAFile != "" {
+		caBundle, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", options.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, fmt.Errorf("both client certificate and key are required for mutual TLS")
+		}
+
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	if options.ProxyURL != "" {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+	}, nil
+}
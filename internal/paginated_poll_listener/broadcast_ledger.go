@@ -0,0 +1,358 @@
+package paginated_poll_listener
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kwilteam/kwil-db/extensions/listeners"
+)
+
+// DefaultBroadcastLedgerRetention is how many windows the broadcast ledger keeps, when it's not configured
+const DefaultBroadcastLedgerRetention = 10_000
+
+var (
+	// broadcastNextIndexKey stores the index of the next record of the broadcast ledger
+	broadcastNextIndexKey = []byte("bl:next")
+	// broadcastFirstSeqKey stores the sequence number of the oldest window kept in the ledger
+	broadcastFirstSeqKey = []byte("bl:seq:first")
+	// broadcastNextSeqKey stores the sequence number of the next window added to the ledger
+	broadcastNextSeqKey = []byte("bl:seq:next")
+)
+
+const (
+	// broadcastSeqKeyPrefix prefixes the start of each window of the ledger, by sequence number.
+	// Windows are added in the order they are processed, so their starts are sorted, and ranges are found by bisection,
+	// as the eventstore can't list keys
+	broadcastSeqKeyPrefix = "bl:seq:"
+	// broadcastWindowKeyPrefix prefixes the records of each window of the ledger, by window start
+	broadcastWindowKeyPrefix = "bl:window:"
+	// broadcastHashKeyPrefix prefixes the window start of each broadcast hash
+	broadcastHashKeyPrefix = "bl:hash:"
+)
+
+// BroadcastRecord is a resolution this node broadcast, that is, voted for
+type BroadcastRecord struct {
+	Index          uint64 `json:"index"`
+	ResolutionName string `json:"resolutionName"`
+	// Hash is the hex SHA-256 of the resolution name and the encoded resolution
+	Hash        string    `json:"hash"`
+	From        int64     `json:"from"`
+	To          int64     `json:"to"`
+	Size        int       `json:"size"`
+	BroadcastAt time.Time `json:"broadcastAt"`
+}
+
+// broadcastWindow holds the records of a window, which may have been broadcast in several chunks
+type broadcastWindow struct {
+	Seq     uint64            `json:"seq"`
+	From    int64             `json:"from"`
+	To      int64             `json:"to"`
+	Records []BroadcastRecord `json:"records"`
+}
+
+// broadcastHash identifies a broadcast by its body. The resolution name is included, as it changes how the body is read
+func broadcastHash(resolutionName string, data []byte) string {
+	h := sha256.New()
+	h.Write([]byte(resolutionName))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func broadcastSeqKey(seq uint64) []byte {
+	return []byte(broadcastSeqKeyPrefix + strconv.FormatUint(seq, 10))
+}
+
+func broadcastWindowKey(from int64) []byte {
+	return []byte(broadcastWindowKeyPrefix + strconv.FormatInt(from, 10))
+}
+
+func broadcastHashKey(hash string) []byte {
+	return []byte(broadcastHashKeyPrefix + hash)
+}
+
+// getUint64 gets a number stored in the eventstore, or 0 if there is none
+func getUint64(ctx context.Context, eventstore listeners.EventStore, key []byte) (uint64, error) {
+	value, err := eventstore.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+func setUint64(ctx context.Context, eventstore listeners.EventStore, key []byte, value uint64) error {
+	return eventstore.Set(ctx, key, binary.LittleEndian.AppendUint64(nil, value))
+}
+
+// getBroadcastSeqBounds gets the sequence numbers of the windows in the ledger, FIRST (inclusive) to NEXT (exclusive)
+func getBroadcastSeqBounds(ctx context.Context, eventstore listeners.EventStore) (uint64, uint64, error) {
+	first, err := getUint64(ctx, eventstore, broadcastFirstSeqKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get first broadcast window: %w", err)
+	}
+	next, err := getUint64(ctx, eventstore, broadcastNextSeqKey)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get next broadcast window: %w", err)
+	}
+	return first, next, nil
+}
+
+// getBroadcastWindowStart gets the start of the window with the sequence number
+func getBroadcastWindowStart(ctx context.Context, eventstore listeners.EventStore, seq uint64) (int64, error) {
+	start, err := getUint64(ctx, eventstore, broadcastSeqKey(seq))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get broadcast window %d: %w", seq, err)
+	}
+	return int64(start), nil
+}
+
+// getBroadcastWindow gets the records of the window starting at FROM, or nil if it's not in the ledger
+func getBroadcastWindow(ctx context.Context, eventstore listeners.EventStore, from int64) (*broadcastWindow, error) {
+	value, err := eventstore.Get(ctx, broadcastWindowKey(from))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast window from %d: %w", from, err)
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var window broadcastWindow
+	err = json.Unmarshal(value, &window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode broadcast window from %d: %w", from, err)
+	}
+	return &window, nil
+}
+
+func setBroadcastWindow(ctx context.Context, eventstore listeners.EventStore, window *broadcastWindow) error {
+	value, err := json.Marshal(window)
+	if err != nil {
+		return fmt.Errorf("failed to encode broadcast window from %d: %w", window.From, err)
+	}
+
+	err = eventstore.Set(ctx, broadcastWindowKey(window.From), value)
+	if err != nil {
+		return fmt.Errorf("failed to set broadcast window from %d: %w", window.From, err)
+	}
+	return nil
+}
+
+// indexBroadcastWindow adds the window to the ledger, if it's newer than every window in it, and returns its records.
+// It returns nil for older windows that are not in the ledger, such as windows pruned already.
+func indexBroadcastWindow(ctx context.Context, eventstore listeners.EventStore, from, to int64) (*broadcastWindow, error) {
+	window, err := getBroadcastWindow(ctx, eventstore, from)
+	if err != nil || window != nil {
+		return window, err
+	}
+
+	first, next, err := getBroadcastSeqBounds(ctx, eventstore)
+	if err != nil {
+		return nil, err
+	}
+	if next > first {
+		last, err := getBroadcastWindowStart(ctx, eventstore, next-1)
+		if err != nil {
+			return nil, err
+		}
+		// the window starts are kept sorted
+		if from <= last {
+			return nil, nil
+		}
+	}
+
+	window = &broadcastWindow{Seq: next, From: from, To: to}
+	err = setBroadcastWindow(ctx, eventstore, window)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setUint64(ctx, eventstore, broadcastSeqKey(next), uint64(from))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set broadcast window %d: %w", next, err)
+	}
+
+	err = setUint64(ctx, eventstore, broadcastNextSeqKey, next+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set next broadcast window: %w", err)
+	}
+	return window, nil
+}
+
+// isBroadcast tells if the exact same resolution was broadcast before
+func isBroadcast(ctx context.Context, eventstore listeners.EventStore, hash string) (bool, error) {
+	value, err := eventstore.Get(ctx, broadcastHashKey(hash))
+	if err != nil {
+		return false, fmt.Errorf("failed to get broadcast %s: %w", hash, err)
+	}
+	return len(value) > 0, nil
+}
+
+// recordBroadcast adds a successful broadcast to the ledger.
+// Broadcasts of windows older than the ledger, such as dead letters of pruned windows, are not recorded,
+// as these windows are never fetched again.
+func recordBroadcast(ctx context.Context, eventstore listeners.EventStore, record BroadcastRecord) error {
+	window, err := indexBroadcastWindow(ctx, eventstore, record.From, record.To)
+	if err != nil {
+		return err
+	}
+	if window == nil {
+		return nil
+	}
+
+	record.Index, err = getUint64(ctx, eventstore, broadcastNextIndexKey)
+	if err != nil {
+		return fmt.Errorf("failed to get next broadcast index: %w", err)
+	}
+
+	window.Records = append(window.Records, record)
+	err = setBroadcastWindow(ctx, eventstore, window)
+	if err != nil {
+		return err
+	}
+
+	err = setUint64(ctx, eventstore, broadcastNextIndexKey, record.Index+1)
+	if err != nil {
+		return fmt.Errorf("failed to set next broadcast index: %w", err)
+	}
+
+	// the hash is set last, so a broadcast is only skipped once it's in the ledger
+	err = setUint64(ctx, eventstore, broadcastHashKey(record.Hash), uint64(record.From))
+	if err != nil {
+		return fmt.Errorf("failed to set broadcast %s: %w", record.Hash, err)
+	}
+	return nil
+}
+
+// broadcastOnce broadcasts the resolution, unless the exact same one was broadcast before.
+// It tells if the resolution was broadcast now.
+func broadcastOnce(ctx context.Context, eventstore listeners.EventStore, resolutionName string, from, to int64, data []byte) (bool, error) {
+	hash := broadcastHash(resolutionName, data)
+	broadcast, err := isBroadcast(ctx, eventstore, hash)
+	if err != nil {
+		return false, err
+	}
+	if broadcast {
+		return false, nil
+	}
+
+	err = eventstore.Broadcast(ctx, resolutionName, data)
+	if err != nil {
+		return false, err
+	}
+
+	err = recordBroadcast(ctx, eventstore, BroadcastRecord{
+		ResolutionName: resolutionName,
+		Hash:           hash,
+		From:           from,
+		To:             to,
+		Size:           len(data),
+		BroadcastAt:    time.Now(),
+	})
+	if err != nil {
+		// the broadcast itself went through, so it must not be retried
+		return true, fmt.Errorf("failed to record broadcast in the ledger: %w", err)
+	}
+	return true, nil
+}
+
+// pruneBroadcasts deletes the oldest windows of the ledger, keeping the last RETENTION windows.
+// Only windows behind the checkpoint are fetched again, so their broadcasts don't need to be remembered forever.
+func pruneBroadcasts(ctx context.Context, eventstore listeners.EventStore, retention int) error {
+	if retention < 1 {
+		retention = DefaultBroadcastLedgerRetention
+	}
+
+	first, next, err := getBroadcastSeqBounds(ctx, eventstore)
+	if err != nil {
+		return err
+	}
+
+	for ; next-first > uint64(retention); first++ {
+		from, err := getBroadcastWindowStart(ctx, eventstore, first)
+		if err != nil {
+			return err
+		}
+
+		window, err := getBroadcastWindow(ctx, eventstore, from)
+		if err != nil {
+			return err
+		}
+		if window != nil {
+			for _, record := range window.Records {
+				err = eventstore.Delete(ctx, broadcastHashKey(record.Hash))
+				if err != nil {
+					return fmt.Errorf("failed to delete broadcast %s: %w", record.Hash, err)
+				}
+			}
+			err = eventstore.Delete(ctx, broadcastWindowKey(from))
+			if err != nil {
+				return fmt.Errorf("failed to delete broadcast window from %d: %w", from, err)
+			}
+		}
+
+		err = eventstore.Delete(ctx, broadcastSeqKey(first))
+		if err != nil {
+			return fmt.Errorf("failed to delete broadcast window %d: %w", first, err)
+		}
+
+		// moved on every window, so an interrupted prune carries on from there
+		err = setUint64(ctx, eventstore, broadcastFirstSeqKey, first+1)
+		if err != nil {
+			return fmt.Errorf("failed to set first broadcast window: %w", err)
+		}
+	}
+	return nil
+}
+
+// ListBroadcasts returns the resolutions this node broadcast for the windows starting from FROM (inclusive) to TO (exclusive),
+// by window, in the order they were broadcast. Only the windows kept by the ledger retention are listed.
+func ListBroadcasts(ctx context.Context, eventstore listeners.EventStore, from, to int64) ([]BroadcastRecord, error) {
+	first, next, err := getBroadcastSeqBounds(ctx, eventstore)
+	if err != nil {
+		return nil, err
+	}
+
+	// bisect the first window starting at FROM or later
+	low, high := first, next
+	for low < high {
+		middle := low + (high-low)/2
+		start, err := getBroadcastWindowStart(ctx, eventstore, middle)
+		if err != nil {
+			return nil, err
+		}
+		if start < from {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+
+	var records []BroadcastRecord
+	for seq := low; seq < next; seq++ {
+		start, err := getBroadcastWindowStart(ctx, eventstore, seq)
+		if err != nil {
+			return nil, err
+		}
+		if start >= to {
+			break
+		}
+
+		window, err := getBroadcastWindow(ctx, eventstore, start)
+		if err != nil {
+			return nil, err
+		}
+		if window != nil {
+			records = append(records, window.Records...)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,104 @@
+package paginated_poll_listener
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwilteam/kwil-db/common"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
+	"gotest.tools/assert"
+)
+
+func Test_BroadcastLedger(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte)}
+	service := &common.Service{Logger: log.NewNoOp().Sugar()}
+
+	paginatedPoller := PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{
+		PollerService:    &windowPoller{failAt: -1},
+		KeyingService:    windowKeying{currentKey: 45},
+		IngestResolution: *ingest_resolution.LogStoreIngestResolution,
+	}
+
+	err := paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.broadcasts), 3)
+
+	// losing the progress fetches the windows again, but their resolutions are not broadcast twice
+	err = eventstore.Delete(ctx, lastKeyKey)
+	assert.NilError(t, err)
+	err = paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.broadcasts), 3)
+
+	records, err := ListBroadcasts(ctx, eventstore, 20, 40)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 2)
+	for i, record := range records {
+		assert.Equal(t, record.Index, uint64(i+1))
+		assert.Equal(t, record.From, int64(20+10*i))
+		assert.Equal(t, record.To, int64(30+10*i))
+		assert.Equal(t, record.ResolutionName, paginatedPoller.IngestResolution.ResolutionName)
+		assert.Equal(t, record.Hash, broadcastHash(record.ResolutionName, eventstore.broadcasts[i+1]))
+	}
+
+	// a dead letter that was broadcast meanwhile is dropped
+	err = pushDeadLetter(ctx, eventstore, DeadLetterPolicy{InitialInterval: time.Nanosecond}, DeadLetter{
+		ResolutionName: paginatedPoller.IngestResolution.ResolutionName,
+		Data:           eventstore.broadcasts[0],
+	})
+	assert.NilError(t, err)
+	time.Sleep(time.Millisecond)
+	err = paginatedPoller.retryDeadLetters(ctx, eventstore, service.Logger)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.broadcasts), 3)
+	deadLetters, err := ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 0)
+}
+
+func Test_BroadcastLedgerRetention(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte)}
+	service := &common.Service{Logger: log.NewNoOp().Sugar()}
+
+	paginatedPoller := PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{
+		PollerService:            &windowPoller{failAt: -1},
+		KeyingService:            windowKeying{currentKey: 65},
+		IngestResolution:         *ingest_resolution.LogStoreIngestResolution,
+		BroadcastLedgerRetention: 2,
+	}
+
+	err := paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.broadcasts), 5)
+
+	// only the last 2 windows are kept, with their hashes
+	records, err := ListBroadcasts(ctx, eventstore, 0, math.MaxInt64)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, records[0].From, int64(40))
+	assert.Equal(t, records[1].From, int64(50))
+
+	hashes := 0
+	for key := range eventstore.values {
+		if strings.HasPrefix(key, broadcastHashKeyPrefix) {
+			hashes++
+		}
+	}
+	assert.Equal(t, hashes, 2)
+
+	// ranges are read from the windows kept
+	records, err = ListBroadcasts(ctx, eventstore, 45, 60)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 1)
+	assert.Equal(t, records[0].From, int64(50))
+
+	records, err = ListBroadcasts(ctx, eventstore, 0, 40)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 0)
+}
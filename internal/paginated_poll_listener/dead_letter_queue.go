@@ -0,0 +1,336 @@
+package paginated_poll_listener
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/kwilteam/kwil-db/extensions/listeners"
+)
+
+// deadLettersMetric is the number of resolutions waiting in the dead-letter queue
+var deadLettersMetric = expvar.NewInt("paginated_poll_listener_dead_letters")
+
+var (
+	// deadLetterNextIdKey stores the id of the next entry of the dead-letter queue
+	deadLetterNextIdKey = []byte("dlq:next")
+	// deadLetterIndexKey stores the ids of the entries, as the eventstore can't list keys
+	deadLetterIndexKey = []byte("dlq:index")
+	// deadLetterPurgeKey stores the last purge requested by the configuration, so it only runs once
+	deadLetterPurgeKey = []byte("dlq:purge")
+)
+
+// deadLetterKeyPrefix prefixes the eventstore keys of the entries of the dead-letter queue
+const deadLetterKeyPrefix = "dlq:entry:"
+
+const (
+	DefaultDeadLetterInitialInterval = time.Minute
+	DefaultDeadLetterMaxInterval     = time.Hour
+)
+
+// DeadLetterPolicy configures how resolutions that failed to broadcast are retried.
+// Zero values use the defaults.
+type DeadLetterPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+// DeadLetter is an encoded resolution that failed to broadcast, kept to be broadcast again on later runs
+type DeadLetter struct {
+	Id             uint64    `json:"id"`
+	ResolutionName string    `json:"resolutionName"`
+	From           int64     `json:"from"`
+	To             int64     `json:"to"`
+	Data           []byte    `json:"data"`
+	Attempts       int       `json:"attempts"`
+	LastError      string    `json:"lastError"`
+	CreatedAt      time.Time `json:"createdAt"`
+	NextRetry      time.Time `json:"nextRetry"`
+}
+
+func (p DeadLetterPolicy) newBackOff() *backoff.ExponentialBackOff {
+	expBackoff := backoff.NewExponentialBackOff()
+	expBackoff.InitialInterval = DefaultDeadLetterInitialInterval
+	if p.InitialInterval > 0 {
+		expBackoff.InitialInterval = p.InitialInterval
+	}
+	expBackoff.MaxInterval = DefaultDeadLetterMaxInterval
+	if p.MaxInterval > 0 {
+		expBackoff.MaxInterval = p.MaxInterval
+	}
+	// dead letters are retried until they are broadcast or purged
+	expBackoff.MaxElapsedTime = 0
+	expBackoff.Reset()
+	return expBackoff
+}
+
+// retryAfter is the wait before the next attempt, after the given failed attempts
+func (p DeadLetterPolicy) retryAfter(attempts int) time.Duration {
+	expBackoff := p.newBackOff()
+	wait := expBackoff.NextBackOff()
+	for i := 1; i < attempts; i++ {
+		wait = expBackoff.NextBackOff()
+	}
+	return wait
+}
+
+func deadLetterKey(id uint64) []byte {
+	return []byte(deadLetterKeyPrefix + strconv.FormatUint(id, 10))
+}
+
+func getDeadLetterIndex(ctx context.Context, eventstore listeners.EventStore) ([]uint64, error) {
+	value, err := eventstore.Get(ctx, deadLetterIndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead-letter index: %w", err)
+	}
+
+	ids := make([]uint64, 0, len(value)/8)
+	for i := 0; i+8 <= len(value); i += 8 {
+		ids = append(ids, binary.LittleEndian.Uint64(value[i:i+8]))
+	}
+	return ids, nil
+}
+
+func setDeadLetterIndex(ctx context.Context, eventstore listeners.EventStore, ids []uint64) error {
+	deadLettersMetric.Set(int64(len(ids)))
+
+	if len(ids) == 0 {
+		err := eventstore.Delete(ctx, deadLetterIndexKey)
+		if err != nil {
+			return fmt.Errorf("failed to delete dead-letter index: %w", err)
+		}
+		return nil
+	}
+
+	value := make([]byte, 0, len(ids)*8)
+	for _, id := range ids {
+		value = binary.LittleEndian.AppendUint64(value, id)
+	}
+	err := eventstore.Set(ctx, deadLetterIndexKey, value)
+	if err != nil {
+		return fmt.Errorf("failed to set dead-letter index: %w", err)
+	}
+	return nil
+}
+
+func getDeadLetter(ctx context.Context, eventstore listeners.EventStore, id uint64) (*DeadLetter, error) {
+	value, err := eventstore.Get(ctx, deadLetterKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter %d: %w", id, err)
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	var deadLetter DeadLetter
+	err = json.Unmarshal(value, &deadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dead letter %d: %w", id, err)
+	}
+	return &deadLetter, nil
+}
+
+func setDeadLetter(ctx context.Context, eventstore listeners.EventStore, deadLetter *DeadLetter) error {
+	value, err := json.Marshal(deadLetter)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter %d: %w", deadLetter.Id, err)
+	}
+
+	err = eventstore.Set(ctx, deadLetterKey(deadLetter.Id), value)
+	if err != nil {
+		return fmt.Errorf("failed to set dead letter %d: %w", deadLetter.Id, err)
+	}
+	return nil
+}
+
+// pushDeadLetter stores a resolution that failed to broadcast, to be retried after the first backoff interval
+func pushDeadLetter(ctx context.Context, eventstore listeners.EventStore, policy DeadLetterPolicy, deadLetter DeadLetter) error {
+	nextId, err := eventstore.Get(ctx, deadLetterNextIdKey)
+	if err != nil {
+		return fmt.Errorf("failed to get next dead-letter id: %w", err)
+	}
+	if len(nextId) == 8 {
+		deadLetter.Id = binary.LittleEndian.Uint64(nextId)
+	}
+
+	now := time.Now()
+	deadLetter.Attempts = 1
+	deadLetter.CreatedAt = now
+	deadLetter.NextRetry = now.Add(policy.retryAfter(deadLetter.Attempts))
+
+	err = setDeadLetter(ctx, eventstore, &deadLetter)
+	if err != nil {
+		return err
+	}
+
+	err = eventstore.Set(ctx, deadLetterNextIdKey, binary.LittleEndian.AppendUint64(nil, deadLetter.Id+1))
+	if err != nil {
+		return fmt.Errorf("failed to set next dead-letter id: %w", err)
+	}
+
+	ids, err := getDeadLetterIndex(ctx, eventstore)
+	if err != nil {
+		return err
+	}
+	return setDeadLetterIndex(ctx, eventstore, append(ids, deadLetter.Id))
+}
+
+// ListDeadLetters returns the entries of the dead-letter queue, oldest first
+func ListDeadLetters(ctx context.Context, eventstore listeners.EventStore) ([]DeadLetter, error) {
+	ids, err := getDeadLetterIndex(ctx, eventstore)
+	if err != nil {
+		return nil, err
+	}
+
+	deadLetters := make([]DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		deadLetter, err := getDeadLetter(ctx, eventstore, id)
+		if err != nil {
+			return nil, err
+		}
+		if deadLetter != nil {
+			deadLetters = append(deadLetters, *deadLetter)
+		}
+	}
+	return deadLetters, nil
+}
+
+// PurgeDeadLetters deletes entries of the dead-letter queue, giving up on broadcasting them.
+// Without ids, every entry is deleted. It returns how many entries were deleted.
+func PurgeDeadLetters(ctx context.Context, eventstore listeners.EventStore, ids ...uint64) (int, error) {
+	stored, err := getDeadLetterIndex(ctx, eventstore)
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []uint64
+	purged := 0
+	for _, id := range stored {
+		if len(ids) > 0 && !slices.Contains(ids, id) {
+			kept = append(kept, id)
+			continue
+		}
+
+		err = eventstore.Delete(ctx, deadLetterKey(id))
+		if err != nil {
+			return purged, fmt.Errorf("failed to delete dead letter %d: %w", id, err)
+		}
+		purged++
+	}
+
+	return purged, setDeadLetterIndex(ctx, eventstore, kept)
+}
+
+// PurgeDeadLettersOnce runs a purge requested by the configuration, unless the same purge already ran.
+// Otherwise, a purge left in the configuration would delete the entries queued after it on every start.
+// It returns how many entries were deleted, and if the purge ran. See [PurgeDeadLetters].
+func PurgeDeadLettersOnce(ctx context.Context, eventstore listeners.EventStore, ids ...uint64) (int, bool, error) {
+	request := purgeRequest(ids)
+	lastRequest, err := eventstore.Get(ctx, deadLetterPurgeKey)
+	if err != nil {
+		return 0, false, err
+	}
+	if string(lastRequest) == request {
+		return 0, false, nil
+	}
+
+	purged, err := PurgeDeadLetters(ctx, eventstore, ids...)
+	if err != nil {
+		return purged, true, err
+	}
+	return purged, true, eventstore.Set(ctx, deadLetterPurgeKey, []byte(request))
+}
+
+// ForgetDeadLetterPurge forgets the last purge run by [PurgeDeadLettersOnce], so the same purge can be requested again
+func ForgetDeadLetterPurge(ctx context.Context, eventstore listeners.EventStore) error {
+	return eventstore.Delete(ctx, deadLetterPurgeKey)
+}
+
+// purgeRequest identifies a purge by its ids, in any order, or as "all" without them
+func purgeRequest(ids []uint64) string {
+	if len(ids) == 0 {
+		return "all"
+	}
+
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	request := make([]string, 0, len(sorted))
+	for _, id := range sorted {
+		request = append(request, strconv.FormatUint(id, 10))
+	}
+	return strings.Join(request, ",")
+}
+
+// retryDeadLetters broadcasts again the entries of the dead-letter queue whose backoff is over.
+// Entries that fail again wait longer, until they are broadcast or purged.
+func (p *PaginatedPoller[T]) retryDeadLetters(ctx context.Context, eventstore listeners.EventStore, logger log.SugaredLogger) error {
+	ids, err := getDeadLetterIndex(ctx, eventstore)
+	if err != nil {
+		return err
+	}
+	deadLettersMetric.Set(int64(len(ids)))
+
+	var kept []uint64
+	for i, id := range ids {
+		if ctx.Err() != nil {
+			kept = append(kept, ids[i:]...)
+			break
+		}
+
+		deadLetter, err := getDeadLetter(ctx, eventstore, id)
+		if err != nil {
+			return err
+		}
+		// the entry is gone, so is its id
+		if deadLetter == nil {
+			continue
+		}
+
+		if time.Now().Before(deadLetter.NextRetry) {
+			kept = append(kept, id)
+			continue
+		}
+
+		broadcast, err := broadcastOnce(ctx, eventstore, deadLetter.ResolutionName, deadLetter.From, deadLetter.To, deadLetter.Data)
+		if !broadcast && err != nil {
+			deadLetter.Attempts++
+			deadLetter.LastError = err.Error()
+			deadLetter.NextRetry = time.Now().Add(p.DeadLetterPolicy.retryAfter(deadLetter.Attempts))
+			logger.Warn(fmt.Sprintf("failed to broadcast dead letter %d from %d to %d, attempt %d, retrying at %s: %v",
+				id, deadLetter.From, deadLetter.To, deadLetter.Attempts, deadLetter.NextRetry.Format(time.RFC3339), err))
+
+			err = setDeadLetter(ctx, eventstore, deadLetter)
+			if err != nil {
+				return err
+			}
+			kept = append(kept, id)
+			continue
+		}
+
+		switch {
+		case !broadcast:
+			logger.Info(fmt.Sprintf("dropped dead letter %d from %d to %d, it was already broadcast", id, deadLetter.From, deadLetter.To))
+		case err != nil:
+			logger.Warn(fmt.Sprintf("broadcasted dead letter %d from %d to %d, but %v", id, deadLetter.From, deadLetter.To, err))
+		default:
+			logger.Info(fmt.Sprintf("broadcasted dead letter %d from %d to %d after %d attempts", id, deadLetter.From, deadLetter.To, deadLetter.Attempts))
+		}
+		err = eventstore.Delete(ctx, deadLetterKey(id))
+		if err != nil {
+			return fmt.Errorf("failed to delete dead letter %d: %w", id, err)
+		}
+	}
+
+	if len(kept) == len(ids) {
+		return nil
+	}
+	return setDeadLetterIndex(ctx, eventstore, kept)
+}
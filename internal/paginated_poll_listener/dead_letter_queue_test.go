@@ -0,0 +1,142 @@
+package paginated_poll_listener
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
+	"gotest.tools/assert"
+)
+
+// memoryEventStore is an in-memory eventstore, whose broadcasts fail while failBroadcast is set
+type memoryEventStore struct {
+	values        map[string][]byte
+	broadcasts    [][]byte
+	failBroadcast bool
+}
+
+func (s *memoryEventStore) Broadcast(_ context.Context, _ string, data []byte) error {
+	if s.failBroadcast {
+		return errors.New("broadcast failed")
+	}
+	s.broadcasts = append(s.broadcasts, data)
+	return nil
+}
+
+func (s *memoryEventStore) Set(_ context.Context, key []byte, value []byte) error {
+	s.values[string(key)] = value
+	return nil
+}
+
+func (s *memoryEventStore) Get(_ context.Context, key []byte) ([]byte, error) {
+	return s.values[string(key)], nil
+}
+
+func (s *memoryEventStore) Delete(_ context.Context, key []byte) error {
+	delete(s.values, string(key))
+	return nil
+}
+
+func Test_DeadLetterQueue(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte), failBroadcast: true}
+	// a tiny interval makes entries due right away
+	policy := DeadLetterPolicy{InitialInterval: time.Nanosecond, MaxInterval: time.Nanosecond}
+	poller := &PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{DeadLetterPolicy: policy}
+	logger := log.NewNoOp().Sugar()
+
+	for i := int64(0); i < 3; i++ {
+		err := pushDeadLetter(ctx, eventstore, policy, DeadLetter{ResolutionName: "resolution", From: i, To: i + 1, Data: []byte{byte(i)}})
+		assert.NilError(t, err)
+	}
+
+	deadLetters, err := ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 3)
+	for i, deadLetter := range deadLetters {
+		assert.Equal(t, deadLetter.Id, uint64(i))
+		assert.Equal(t, deadLetter.Attempts, 1)
+	}
+
+	// failures are kept, with one more attempt
+	time.Sleep(time.Millisecond)
+	err = poller.retryDeadLetters(ctx, eventstore, logger)
+	assert.NilError(t, err)
+	deadLetters, err = ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 3)
+	assert.Equal(t, deadLetters[0].Attempts, 2)
+	assert.Equal(t, deadLetters[0].LastError, "broadcast failed")
+
+	purged, err := PurgeDeadLetters(ctx, eventstore, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, purged, 1)
+
+	// successes leave the queue
+	eventstore.failBroadcast = false
+	time.Sleep(time.Millisecond)
+	err = poller.retryDeadLetters(ctx, eventstore, logger)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, eventstore.broadcasts, [][]byte{{0}, {2}})
+
+	deadLetters, err = ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 0)
+
+	// ids are not reused after the queue is emptied
+	err = pushDeadLetter(ctx, eventstore, policy, DeadLetter{ResolutionName: "resolution"})
+	assert.NilError(t, err)
+	deadLetters, err = ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 1)
+	assert.Equal(t, deadLetters[0].Id, uint64(3))
+
+	purged, err = PurgeDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, purged, 1)
+	for key := range eventstore.values {
+		assert.Assert(t, !strings.HasPrefix(key, "dlq:") || key == string(deadLetterNextIdKey), "%s is left", key)
+	}
+}
+
+func Test_PurgeDeadLettersOnce(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte)}
+	policy := DeadLetterPolicy{}
+
+	err := pushDeadLetter(ctx, eventstore, policy, DeadLetter{ResolutionName: "resolution"})
+	assert.NilError(t, err)
+
+	purged, ran, err := PurgeDeadLettersOnce(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Assert(t, ran)
+	assert.Equal(t, purged, 1)
+
+	// entries queued after the purge survive it being requested again
+	err = pushDeadLetter(ctx, eventstore, policy, DeadLetter{ResolutionName: "resolution"})
+	assert.NilError(t, err)
+	purged, ran, err = PurgeDeadLettersOnce(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Assert(t, !ran)
+	assert.Equal(t, purged, 0)
+
+	// a different purge runs
+	purged, ran, err = PurgeDeadLettersOnce(ctx, eventstore, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, ran)
+	assert.Equal(t, purged, 1)
+
+	// once forgotten, the same purge runs again
+	_, ran, err = PurgeDeadLettersOnce(ctx, eventstore, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, !ran)
+	err = ForgetDeadLetterPurge(ctx, eventstore)
+	assert.NilError(t, err)
+	_, ran, err = PurgeDeadLettersOnce(ctx, eventstore, 1)
+	assert.NilError(t, err)
+	assert.Assert(t, ran)
+}
@@ -66,3 +66,17 @@ func getLastStoredKey(ctx context.Context, eventstore listeners.EventStore) (*in
 func setLastStoredKey(ctx context.Context, eventstore listeners.EventStore, timestamp int64) error {
 	return setStoredKey(ctx, eventstore, lastKeyKey, timestamp)
 }
+
+// HasStoredKeys tells whether the listener has already stored keys, so the meaning of keys can't change anymore
+func HasStoredKeys(ctx context.Context, eventstore listeners.EventStore) (bool, error) {
+	for _, key := range [][]byte{firstKeyKey, lastKeyKey} {
+		storedKey, err := getStoredKey(ctx, eventstore, key)
+		if err != nil {
+			return false, err
+		}
+		if storedKey != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
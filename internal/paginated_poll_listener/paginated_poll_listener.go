@@ -13,26 +13,43 @@ type PaginatedPoller[T ingest_resolution.IngestDataResolution] struct {
 	PollerService    PollerService[T]
 	KeyingService    KeyingService
 	IngestResolution ingest_resolution.IngestResolution[T]
+	// DeadLetterPolicy configures how resolutions that failed to broadcast are retried on later runs
+	DeadLetterPolicy DeadLetterPolicy
+	// BroadcastLedgerRetention is how many windows the broadcast ledger keeps. It defaults to [DefaultBroadcastLedgerRetention]
+	BroadcastLedgerRetention int
 }
 
 type PollerService[T ingest_resolution.IngestDataResolution] interface {
 	// GetData gets the data from the service from the given key range. FROM (inclusive) and TO (exclusive)
-	GetData(from, to int64) (*T, error)
+	GetData(ctx context.Context, from, to int64) (*T, error)
 	// EmptyResolutionSize returns the size of the empty resolution
 	EmptyResolutionSize() int
 }
 
+// WindowCommitter is implemented by poller services that keep state about the windows they fetch.
+// CommitWindow is called once the data GetData returned for the window was broadcast, right before its progress is stored.
+// Windows that failed aren't committed, and are fetched again on a later run.
+type WindowCommitter interface {
+	CommitWindow(ctx context.Context, from, to int64) error
+}
+
 // KeyingService helps to get the starting key, current key, key after and key before.
 // Key here means the key of the data that we are processing, it could be a block number, a timestamp, etc.
 // For now it's an int64, but it could be any type that can be compared.
 type KeyingService interface {
-	GetStartingKey() (int64, error)
-	GetCurrentKey() (int64, error)
-	GetKeyAfter(key int64) (int64, error)
-	GetKeyBefore(key int64) (int64, error)
+	GetStartingKey(ctx context.Context) (int64, error)
+	GetCurrentKey(ctx context.Context) (int64, error)
+	GetKeyAfter(ctx context.Context, key int64) (int64, error)
+	GetKeyBefore(ctx context.Context, key int64) (int64, error)
 }
 
 func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, eventstore listeners.EventStore) error {
+	// failures here don't block new windows, the dead letters are retried on the next run
+	err := p.retryDeadLetters(ctx, eventstore, service.Logger)
+	if err != nil {
+		service.Logger.Warn(fmt.Sprintf("failed to retry dead letters: %v", err))
+	}
+
 	lastProcessedKeyRef, err := getLastStoredKey(ctx, eventstore)
 	if err != nil {
 		return fmt.Errorf("failed to get last stored key: %w", err)
@@ -47,7 +64,7 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 		return fmt.Errorf("failed to get starting key: %w", err)
 	}
 
-	currentKey, err := p.KeyingService.GetCurrentKey()
+	currentKey, err := p.KeyingService.GetCurrentKey(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get current key: %w", err)
 	}
@@ -55,7 +72,7 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 	var startingKey int64
 	if startingKeyRef == nil {
 		// starting key should not change, that's why we store it in the kv store
-		startingKey, err = p.KeyingService.GetStartingKey()
+		startingKey, err = p.KeyingService.GetStartingKey(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to get starting key: %w", err)
 		}
@@ -82,7 +99,7 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 	// e.g., for a service that processes every 10 keys. current key = 102, last processed key = 80
 	// - The ending key would be 100;
 	// - We expect to process data from 80 to 100, as 100 forward batch is ongoing.
-	endingKey, err := p.KeyingService.GetKeyBefore(currentKey)
+	endingKey, err := p.KeyingService.GetKeyBefore(ctx, currentKey)
 	if err != nil {
 		return fmt.Errorf("failed to get ending key: %w", err)
 	}
@@ -90,7 +107,12 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 	var nextKey int64
 	// we will now process the data from the last processed key to the ending key
 	for {
-		nextKey, err = p.KeyingService.GetKeyAfter(lastProcessedKey)
+		// stop catching up if the node is shutting down, keeping the progress made so far
+		if ctx.Err() != nil {
+			break
+		}
+
+		nextKey, err = p.KeyingService.GetKeyAfter(ctx, lastProcessedKey)
 
 		// should never happen
 		if lastProcessedKey > nextKey {
@@ -117,14 +139,35 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 			service.Logger.Warn("partially failed to process data, but continuing to next keys: %v", processErrors.Errors)
 		}
 
+		if committer, ok := p.PollerService.(WindowCommitter); ok {
+			err = committer.CommitWindow(ctx, lastProcessedKey, nextKey)
+			if err != nil {
+				return fmt.Errorf("failed to commit window: %w", err)
+			}
+		}
+
+		// the progress is stored right after the window is broadcast, so a failure or a crash
+		// in a later window doesn't make this one be fetched and broadcast again.
+		// The eventstore has no transactions, so a crash between both fetches this window again, but the broadcast ledger skips it.
+		err = setLastStoredKey(ctx, eventstore, nextKey)
+		if err != nil {
+			return fmt.Errorf("failed to set last key: %w", err)
+		}
 		lastProcessedKey = nextKey
-	}
 
-	// set the last key processed by the listener
-	err = setLastStoredKey(ctx, eventstore, lastProcessedKey)
+		// the ledger only needs the windows that may be fetched again, failing to prune it doesn't stop the progress
+		err = pruneBroadcasts(ctx, eventstore, p.BroadcastLedgerRetention)
+		if err != nil {
+			service.Logger.Warn(fmt.Sprintf("failed to prune the broadcast ledger: %v", err))
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to set last key: %w", err)
+	// the starting key may be ahead of the stored one, even without windows processed
+	if lastProcessedKeyRef == nil || *lastProcessedKeyRef != lastProcessedKey {
+		err = setLastStoredKey(ctx, eventstore, lastProcessedKey)
+		if err != nil {
+			return fmt.Errorf("failed to set last key: %w", err)
+		}
 	}
 
 	return nil
@@ -133,7 +176,8 @@ func (p *PaginatedPoller[T]) Run(ctx context.Context, service *common.Service, e
 type ProcessErrors[T any] struct {
 	Errors             []error
 	PartiallyProcessed bool
-	UnprocessedData    []*T
+	// UnprocessedData failed to broadcast. It's stored in the dead-letter queue, unless storing it failed too
+	UnprocessedData []*T
 }
 
 // retrieveAndProcessData will process all data from the PollerService from the given key range.
@@ -149,7 +193,7 @@ func (p *PaginatedPoller[T]) retrieveAndProcessData(
 		UnprocessedData:    nil,
 	}
 
-	ingestDataResolution, err := p.PollerService.GetData(from, to)
+	ingestDataResolution, err := p.PollerService.GetData(ctx, from, to)
 	if err != nil {
 		errors.Errors = append(errors.Errors, fmt.Errorf("failed to get data: %w", err))
 		return &errors
@@ -178,13 +222,40 @@ func (p *PaginatedPoller[T]) retrieveAndProcessData(
 	// we set the partially processed flag to true, as we will continue to process the next key even if there are errors
 	// otherwise messages that couldn't be processed would stop the whole process
 	errors.PartiallyProcessed = true
+
+	// the window is added to the ledger before its broadcasts, so chunks that fail now are recorded
+	// when their dead letters are broadcast
+	_, err = indexBroadcastWindow(ctx, eventstore, from, to)
+	if err != nil {
+		errors.Errors = append(errors.Errors, err)
+	}
+
 	for i := 0; i < len(encodedResolutionResults); i++ {
-		err = eventstore.Broadcast(ctx, p.IngestResolution.ResolutionName, encodedResolutionResults[i])
+		// windows fetched again, such as after a crash, have the same chunks, which were already voted for
+		broadcast, err := broadcastOnce(ctx, eventstore, p.IngestResolution.ResolutionName, from, to, encodedResolutionResults[i])
+		if !broadcast && err == nil {
+			logger.Debug(fmt.Sprintf("skipped resolution %s from %d to %d, it was already broadcast", p.IngestResolution.ResolutionName, from, to))
+			continue
+		}
 
-		if err != nil {
+		if broadcast && err != nil {
+			errors.Errors = append(errors.Errors, err)
+		} else if err != nil {
 			errors.Errors = append(errors.Errors, fmt.Errorf("failed to broadcast resolution: %w", err))
 			resolution := chunkedResolutions[i].(T)
 			errors.UnprocessedData = append(errors.UnprocessedData, &resolution)
+
+			// the window is still marked as processed, so the chunk is kept to be broadcast again on later runs
+			err = pushDeadLetter(ctx, eventstore, p.DeadLetterPolicy, DeadLetter{
+				ResolutionName: p.IngestResolution.ResolutionName,
+				From:           from,
+				To:             to,
+				Data:           encodedResolutionResults[i],
+				LastError:      err.Error(),
+			})
+			if err != nil {
+				errors.Errors = append(errors.Errors, fmt.Errorf("failed to store resolution in the dead-letter queue, it is lost: %w", err))
+			}
 		}
 	}
 
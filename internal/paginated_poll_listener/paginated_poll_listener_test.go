@@ -0,0 +1,144 @@
+package paginated_poll_listener
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kwilteam/kwil-db/common"
+	"github.com/kwilteam/kwil-db/core/log"
+	"github.com/usherlabs/kwil-ls-oracle/internal/extensions/resolutions/ingest_resolution"
+	"gotest.tools/assert"
+)
+
+// windowKeying has windows of 10 keys, from 10 to currentKey
+type windowKeying struct {
+	currentKey int64
+}
+
+func (k windowKeying) GetStartingKey(context.Context) (int64, error) { return 10, nil }
+func (k windowKeying) GetCurrentKey(context.Context) (int64, error)  { return k.currentKey, nil }
+func (k windowKeying) GetKeyAfter(_ context.Context, key int64) (int64, error) {
+	return key + 10, nil
+}
+func (k windowKeying) GetKeyBefore(_ context.Context, key int64) (int64, error) {
+	return key - key%10, nil
+}
+
+// windowPoller returns a message per window, failing at failAt
+type windowPoller struct {
+	failAt    int64
+	fetched   []int64
+	committed []int64
+}
+
+func (p *windowPoller) GetData(_ context.Context, from, _ int64) (**ingest_resolution.LogStoreIngestDataResolution, error) {
+	p.fetched = append(p.fetched, from)
+	if from == p.failAt {
+		return nil, errors.New("node unavailable")
+	}
+	data := &ingest_resolution.LogStoreIngestDataResolution{
+		Messages: []ingest_resolution.LogStoreIngestMessage{{Id: "id", Content: "{}", Timestamp: uint(from)}},
+	}
+	return &data, nil
+}
+
+func (p *windowPoller) EmptyResolutionSize() int {
+	return 0
+}
+
+func (p *windowPoller) CommitWindow(_ context.Context, from, _ int64) error {
+	p.committed = append(p.committed, from)
+	return nil
+}
+
+func Test_RunCheckpointsEachWindow(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte)}
+	service := &common.Service{Logger: log.NewNoOp().Sugar()}
+	poller := &windowPoller{failAt: 50}
+
+	paginatedPoller := PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{
+		PollerService:    poller,
+		KeyingService:    windowKeying{currentKey: 105},
+		IngestResolution: *ingest_resolution.LogStoreIngestResolution,
+	}
+
+	err := paginatedPoller.Run(ctx, service, eventstore)
+	assert.ErrorContains(t, err, "node unavailable")
+	assert.Equal(t, len(eventstore.broadcasts), 4)
+	// the failed window is not committed
+	assert.DeepEqual(t, poller.committed, []int64{10, 20, 30, 40})
+
+	// windows before the failure are not fetched again
+	lastKey, err := getLastStoredKey(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, *lastKey, int64(50))
+
+	poller.failAt = -1
+	poller.fetched = nil
+	err = paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, poller.fetched, []int64{50, 60, 70, 80, 90})
+	assert.Equal(t, len(eventstore.broadcasts), 9)
+
+	lastKey, err = getLastStoredKey(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, *lastKey, int64(100))
+}
+
+func Test_RunRetriesFailedBroadcasts(t *testing.T) {
+	ctx := context.Background()
+	eventstore := &memoryEventStore{values: make(map[string][]byte), failBroadcast: true}
+	service := &common.Service{Logger: log.NewNoOp().Sugar()}
+
+	poller := &windowPoller{failAt: -1}
+	paginatedPoller := PaginatedPoller[*ingest_resolution.LogStoreIngestDataResolution]{
+		PollerService:    poller,
+		KeyingService:    windowKeying{currentKey: 25},
+		IngestResolution: *ingest_resolution.LogStoreIngestResolution,
+		// a tiny interval makes entries due right away
+		DeadLetterPolicy: DeadLetterPolicy{InitialInterval: time.Nanosecond, MaxInterval: time.Nanosecond},
+	}
+
+	// the failed chunk doesn't block the progress, it's kept in the dead-letter queue
+	err := paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(eventstore.broadcasts), 0)
+	lastKey, err := getLastStoredKey(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, *lastKey, int64(20))
+
+	deadLetters, err := ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 1)
+	assert.Equal(t, deadLetters[0].From, int64(10))
+	assert.Equal(t, deadLetters[0].To, int64(20))
+	assert.Equal(t, deadLetters[0].LastError, "broadcast failed")
+
+	// nothing was broadcast yet, so nothing is recorded
+	records, err := ListBroadcasts(ctx, eventstore, 10, 20)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 0)
+
+	// the next run broadcasts it, without fetching the window again
+	eventstore.failBroadcast = false
+	time.Sleep(time.Millisecond)
+	err = paginatedPoller.Run(ctx, service, eventstore)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, poller.fetched, []int64{10})
+	assert.Equal(t, len(eventstore.broadcasts), 1)
+	assert.DeepEqual(t, eventstore.broadcasts[0], deadLetters[0].Data)
+
+	deadLetters, err = ListDeadLetters(ctx, eventstore)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deadLetters), 0)
+
+	records, err = ListBroadcasts(ctx, eventstore, 10, 20)
+	assert.NilError(t, err)
+	assert.Equal(t, len(records), 1)
+	assert.Equal(t, records[0].From, int64(10))
+	assert.Equal(t, records[0].To, int64(20))
+	assert.Equal(t, records[0].Hash, broadcastHash(records[0].ResolutionName, eventstore.broadcasts[0]))
+}